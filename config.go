@@ -0,0 +1,461 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gpeers/vmware-poc/scanner"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a --config file. Every field stands in for the flag of the
+// same name, which overrides it when given on the command line; lists are
+// named in the plural. Durations are written as for the flags, e.g. 10m.
+// JSON works too, being YAML.
+type Config struct {
+	URL        string `yaml:"url"`
+	Insecure   *bool  `yaml:"insecure"`
+	CACert     string `yaml:"ca-cert"`
+	Thumbprint string `yaml:"thumbprint"`
+	ClientCert string `yaml:"client-cert"`
+	ClientKey  string `yaml:"client-key"`
+	Proxy      string `yaml:"proxy"`
+
+	PasswordFile     string `yaml:"password-file"`
+	PasswordStdin    *bool  `yaml:"password-stdin"`
+	HostsFile        string `yaml:"hosts-file"`
+	SessionToken     string `yaml:"session-token"`
+	SessionCache     *bool  `yaml:"session-cache"`
+	ESXiUser         string `yaml:"esxi-user"`
+	ESXiPassword     string `yaml:"esxi-password"`
+	ESXiPasswordFile string `yaml:"esxi-password-file"`
+
+	APIQPS       float64 `yaml:"api-qps"`
+	MaxIdleConns *int    `yaml:"max-idle-conns"`
+	Keepalive    string  `yaml:"keepalive"`
+
+	DiscoveryTimeout string `yaml:"discovery-timeout"`
+	DiscoveryWorkers *int   `yaml:"discovery-workers"`
+
+	Datacenters     []string `yaml:"datacenters"`
+	Cluster         string   `yaml:"cluster"`
+	ResourcePool    string   `yaml:"resource-pool"`
+	Folder          string   `yaml:"folder"`
+	FolderRecursive *bool    `yaml:"folder-recursive"`
+	IncludeHosts    string   `yaml:"include-hosts"`
+	ExcludeHosts    string   `yaml:"exclude-hosts"`
+	Tags            []string `yaml:"tags"`
+	VMNames         []string `yaml:"vm-names"`
+	PowerState      string   `yaml:"power-state"`
+	Transport       string   `yaml:"transport"`
+	Mode            string   `yaml:"mode"`
+	FactsDir        string   `yaml:"facts-dir"`
+	FactCommand     string   `yaml:"fact-command"`
+
+	ExcludeVMNames []string `yaml:"exclude-vm-names"`
+	SkipSystemVMs  *bool    `yaml:"skip-system-vms"`
+	ScanTemplates  *bool    `yaml:"scan-templates"`
+	TargetNetwork  string   `yaml:"target-network"`
+	PreferIPv6     *bool    `yaml:"prefer-ipv6"`
+	OnlyWithTools  *bool    `yaml:"only-with-tools"`
+	WaitForIP      string   `yaml:"wait-for-ip"`
+	Since          string   `yaml:"since"`
+	MaxTargets     int      `yaml:"max-targets"`
+
+	SnapshotBefore  *bool `yaml:"snapshot-before"`
+	SnapshotCleanup *bool `yaml:"snapshot-cleanup"`
+
+	Sudo         *bool  `yaml:"sudo"`
+	SudoPassword string `yaml:"sudo-password"`
+	SudoOptions  string `yaml:"sudo-options"`
+	BastionHost  string `yaml:"bastion-host"`
+	BastionUser  string `yaml:"bastion-user"`
+	BastionPort  int    `yaml:"bastion-port"`
+	WinRMSSL     *bool  `yaml:"winrm-ssl"`
+
+	// Credentials are the guest and host credentials, in the format of a
+	// --credentials file, which replaces them when given
+	Credentials *scanner.CredentialStore `yaml:"credentials"`
+
+	// CredentialChain are credential sources tried in order until one has
+	// a credential for a VM or host, replacing Credentials; --credentials
+	// and --vault-path replace them in turn
+	CredentialChain []scanner.CredentialSource `yaml:"credential-chain"`
+
+	// CredentialsFile is --credentials, which replaces Credentials and
+	// CredentialChain
+	CredentialsFile string `yaml:"credentials-file"`
+	VaultAddr       string `yaml:"vault-addr"`
+	VaultPath       string `yaml:"vault-path"`
+
+	// Bastions are SSH bastions for the guests of their subnets, tried in
+	// order before --bastion-host
+	Bastions []scanner.Bastion `yaml:"bastions"`
+
+	// Ports are the ports of the guests in their subnets, tried in order
+	// before --port
+	Ports []scanner.PortRule `yaml:"ports"`
+	Port  int                `yaml:"port"`
+
+	ProfilesPath string            `yaml:"profiles-path"`
+	ProfileMap   map[string]string `yaml:"profile-map"`
+	Profile      string            `yaml:"profile"`
+	Profiles     []string          `yaml:"profiles"`
+
+	// Inputs are InSpec profile inputs, as in an --input-file, which
+	// replaces them when given
+	Inputs    map[string]interface{} `yaml:"inputs"`
+	InputFile string                 `yaml:"input-file"`
+
+	InspecBin        string   `yaml:"inspec-bin"`
+	InspecArgs       []string `yaml:"inspec-args"`
+	GuestInspecBin   string   `yaml:"guest-inspec-bin"`
+	AutoDetect       *bool    `yaml:"auto-detect"`
+	ValidateProfiles *bool    `yaml:"validate-profiles"`
+
+	// Waivers are InSpec waiver files for the vms and hosts their names
+	// match, on top of the WaiverFiles of every target
+	WaiverFiles []string             `yaml:"waiver-files"`
+	Waivers     []scanner.WaiverRule `yaml:"waivers"`
+
+	Reporters       []string `yaml:"reporters"`
+	ReportDir       string   `yaml:"report-dir"`
+	ReportRunDir    *bool    `yaml:"report-run-dir"`
+	ReportRetention string   `yaml:"report-retention"`
+	CombinedReport  string   `yaml:"combined-report"`
+	Compress        *bool    `yaml:"compress"`
+	HTMLReport      string   `yaml:"html-report"`
+	JUnit           string   `yaml:"junit"`
+	SARIF           string   `yaml:"sarif"`
+	InventoryCSV    string   `yaml:"inventory-csv"`
+	SummaryJSON     *bool    `yaml:"summary-json"`
+	Quiet           *bool    `yaml:"quiet"`
+	SaveStderr      *bool    `yaml:"save-stderr"`
+	S3Bucket        string   `yaml:"s3-bucket"`
+	S3Prefix        string   `yaml:"s3-prefix"`
+	WebhookURL      string   `yaml:"webhook-url"`
+	WebhookFormat   string   `yaml:"webhook-format"`
+	MetricsAddr     string   `yaml:"metrics-addr"`
+	AuditLog        string   `yaml:"audit-log"`
+
+	CacheFile string `yaml:"cache-file"`
+	UseCache  *bool  `yaml:"use-cache"`
+	CacheTTL  string `yaml:"cache-ttl"`
+	Resume    *bool  `yaml:"resume"`
+	Force     *bool  `yaml:"force"`
+
+	CheckConnectivity *bool `yaml:"check-connectivity"`
+	DryRun            *bool `yaml:"dry-run"`
+	Plan              *bool `yaml:"plan"`
+
+	Concurrency      int     `yaml:"concurrency"`
+	Adaptive         *bool   `yaml:"concurrency-adaptive"`
+	MinConcurrency   int     `yaml:"min-concurrency"`
+	FailureWindow    *int    `yaml:"failure-window"`
+	FailureThreshold float64 `yaml:"failure-threshold"`
+	WorkersPerHost   int     `yaml:"workers-per-host"`
+	ScanTimeout      string  `yaml:"scan-timeout"`
+	MaxRetries       *int    `yaml:"max-retries"`
+	RetryBackoff     string  `yaml:"retry-backoff"`
+	FailOn           string  `yaml:"fail-on"`
+	FailFast         *bool   `yaml:"fail-fast"`
+	ShutdownGrace    string  `yaml:"shutdown-grace"`
+	LogLevel         string  `yaml:"log-level"`
+}
+
+// LoadConfig reads a Config from a YAML or JSON file. Unknown keys are an
+// error, so typos don't go unnoticed. ${VAR} in values is replaced by the
+// environment variable, so secrets needn't be in the file.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+
+	raw, err = expandEnv(raw)
+	if err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if b, err = yaml.Marshal(raw); err != nil {
+		return c, err
+	}
+
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// apply sets the flags c has values for, except the ones in set, which
+// were given on the command line.
+func (c Config) apply(set map[string]bool) error {
+	var err error
+
+	value := func(name, v string) {
+		if err == nil && v != "" && !set[name] {
+			err = flag.Set(name, v)
+		}
+	}
+
+	list := func(name string, vs []string) {
+		for _, v := range vs {
+			value(name, v)
+		}
+	}
+
+	boolean := func(name string, v *bool) {
+		if v != nil {
+			value(name, strconv.FormatBool(*v))
+		}
+	}
+
+	number := func(name string, v *int) {
+		if v != nil {
+			value(name, strconv.Itoa(*v))
+		}
+	}
+
+	nonzero := func(name string, v int) {
+		if v != 0 {
+			value(name, strconv.Itoa(v))
+		}
+	}
+
+	float := func(name string, v float64) {
+		if v != 0 {
+			value(name, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+
+	value("url", c.URL)
+	boolean("insecure", c.Insecure)
+	value("ca-cert", c.CACert)
+	value("thumbprint", c.Thumbprint)
+	value("client-cert", c.ClientCert)
+	value("client-key", c.ClientKey)
+	value("password-file", c.PasswordFile)
+	boolean("password-stdin", c.PasswordStdin)
+	value("hosts-file", c.HostsFile)
+	value("session-token", c.SessionToken)
+	boolean("session-cache", c.SessionCache)
+	value("esxi-user", c.ESXiUser)
+	value("esxi-password", c.ESXiPassword)
+	value("esxi-password-file", c.ESXiPasswordFile)
+	value("proxy", c.Proxy)
+	float("api-qps", c.APIQPS)
+	number("max-idle-conns", c.MaxIdleConns)
+	value("keepalive", c.Keepalive)
+	value("discovery-timeout", c.DiscoveryTimeout)
+	number("discovery-workers", c.DiscoveryWorkers)
+
+	value("credentials", c.CredentialsFile)
+	value("vault-addr", c.VaultAddr)
+	value("vault-path", c.VaultPath)
+
+	list("datacenter", c.Datacenters)
+	value("cluster", c.Cluster)
+	value("resource-pool", c.ResourcePool)
+	value("folder", c.Folder)
+	boolean("folder-recursive", c.FolderRecursive)
+	value("include-hosts", c.IncludeHosts)
+	value("exclude-hosts", c.ExcludeHosts)
+	list("tag", c.Tags)
+	list("vm-name", c.VMNames)
+	list("exclude-vm-name", c.ExcludeVMNames)
+	boolean("skip-system-vms", c.SkipSystemVMs)
+	boolean("scan-templates", c.ScanTemplates)
+	value("power-state", c.PowerState)
+	value("transport", c.Transport)
+	value("mode", c.Mode)
+	value("facts-dir", c.FactsDir)
+	value("fact-command", c.FactCommand)
+	nonzero("port", c.Port)
+	value("target-network", c.TargetNetwork)
+	boolean("prefer-ipv6", c.PreferIPv6)
+	boolean("only-with-tools", c.OnlyWithTools)
+	value("wait-for-ip", c.WaitForIP)
+	value("since", c.Since)
+	nonzero("max-targets", c.MaxTargets)
+
+	boolean("snapshot-before", c.SnapshotBefore)
+	boolean("snapshot-cleanup", c.SnapshotCleanup)
+
+	boolean("sudo", c.Sudo)
+	value("sudo-password", c.SudoPassword)
+	value("sudo-options", c.SudoOptions)
+	value("bastion-host", c.BastionHost)
+	value("bastion-user", c.BastionUser)
+	nonzero("bastion-port", c.BastionPort)
+	boolean("winrm-ssl", c.WinRMSSL)
+
+	value("inspec-bin", c.InspecBin)
+	list("inspec-arg", c.InspecArgs)
+	value("guest-inspec-bin", c.GuestInspecBin)
+	boolean("auto-detect", c.AutoDetect)
+	boolean("validate-profiles", c.ValidateProfiles)
+	value("profiles-path", c.ProfilesPath)
+	value("profile-map", formatProfileMap(c.ProfileMap))
+	value("profile", c.Profile)
+	list("profile", c.Profiles)
+	value("input-file", c.InputFile)
+	list("waiver-file", c.WaiverFiles)
+
+	list("reporter", c.Reporters)
+	value("report-dir", c.ReportDir)
+	boolean("report-run-dir", c.ReportRunDir)
+	value("report-retention", c.ReportRetention)
+	value("combined-report", c.CombinedReport)
+	boolean("compress", c.Compress)
+	value("html-report", c.HTMLReport)
+	value("junit", c.JUnit)
+	value("sarif", c.SARIF)
+	value("inventory-csv", c.InventoryCSV)
+	boolean("summary-json", c.SummaryJSON)
+	boolean("quiet", c.Quiet)
+	boolean("save-stderr", c.SaveStderr)
+	value("s3-bucket", c.S3Bucket)
+	value("s3-prefix", c.S3Prefix)
+	value("webhook-url", c.WebhookURL)
+	value("webhook-format", c.WebhookFormat)
+	value("metrics-addr", c.MetricsAddr)
+	value("audit-log", c.AuditLog)
+
+	value("cache-file", c.CacheFile)
+	boolean("use-cache", c.UseCache)
+	value("cache-ttl", c.CacheTTL)
+	boolean("resume", c.Resume)
+	boolean("force", c.Force)
+
+	boolean("check-connectivity", c.CheckConnectivity)
+	boolean("dry-run", c.DryRun)
+	boolean("plan", c.Plan)
+
+	nonzero("concurrency", c.Concurrency)
+	boolean("concurrency-adaptive", c.Adaptive)
+	nonzero("min-concurrency", c.MinConcurrency)
+	number("failure-window", c.FailureWindow)
+	float("failure-threshold", c.FailureThreshold)
+	nonzero("workers-per-host", c.WorkersPerHost)
+	value("scan-timeout", c.ScanTimeout)
+	number("max-retries", c.MaxRetries)
+	value("retry-backoff", c.RetryBackoff)
+	value("fail-on", c.FailOn)
+	boolean("fail-fast", c.FailFast)
+	value("shutdown-grace", c.ShutdownGrace)
+	value("log-level", c.LogLevel)
+
+	return err
+}
+
+// formatProfileMap formats m as a --profile-map value.
+func formatProfileMap(m map[string]string) string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// envRef matches a ${VAR} or ${VAR:-default} reference, or one escaped as
+// $${VAR}.
+var envRef = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// expandEnv returns v with the ${VAR} references in every string in it,
+// however deeply nested, expanded. ${VAR:-default} falls back to default
+// when VAR is unset or empty; any other unset variable is an error. A $
+// that doesn't start a reference is kept, so passwords needn't be escaped,
+// and $${VAR} is a literal ${VAR}. A value that is a number or boolean
+// once expanded is one, so that e.g. port: ${PORT} works.
+func expandEnv(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if !envRef.MatchString(v) {
+			return v, nil
+		}
+
+		var missing []string
+		s := envRef.ReplaceAllStringFunc(v, func(ref string) string {
+			if strings.HasPrefix(ref, "$$") {
+				return ref[1:]
+			}
+
+			name, def, hasDef := strings.Cut(ref[2:len(ref)-1], ":-")
+			if val := os.Getenv(name); val != "" || !hasDef && isSet(name) {
+				return val
+			}
+			if !hasDef {
+				missing = append(missing, name)
+			}
+
+			return def
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+		}
+
+		return scalar(s), nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			r, err := expandEnv(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+
+		return out, nil
+	case map[interface{}]interface{}:
+		out := map[interface{}]interface{}{}
+		for k, e := range v {
+			r, err := expandEnv(e)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+
+		return out, nil
+	}
+
+	return v, nil
+}
+
+// scalar returns s as the int, float or bool it spells out, if it does
+// exactly, so it is written back as it was; anything else, like 0755 or
+// yes, stays a string.
+func scalar(s string) interface{} {
+	if i, err := strconv.Atoi(s); err == nil && strconv.Itoa(i) == s {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == s {
+		return f
+	}
+	if b, err := strconv.ParseBool(s); err == nil && strconv.FormatBool(b) == s {
+		return b
+	}
+
+	return s
+}
+
+// isSet reports whether the environment variable name is set, if empty.
+func isSet(name string) bool {
+	_, ok := os.LookupEnv(name)
+	return ok
+}
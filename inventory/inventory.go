@@ -0,0 +1,406 @@
+// Package inventory discovers the ESXi hosts and virtual machines to scan.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// HostInfo describes an ESXi host. IP is its first management IP, or its
+// name if that can't be read.
+type HostInfo struct {
+	Name          string
+	Datacenter    string
+	InventoryPath string
+	Ref           types.ManagedObjectReference
+	IP            string
+}
+
+// VMInfo describes a virtual machine. Host is the name of the host it was
+// found on, in Datacenter. IP is the guest's primary IP, and Nets its NICs
+// with all their addresses. BootTime is when it was last powered on, and
+// Modified when its configuration last changed; either is zero if unknown.
+// Annotation is the Notes of the VM, often naming its owner.
+type VMInfo struct {
+	Name          string
+	Host          string
+	HostRef       types.ManagedObjectReference
+	Datacenter    string
+	InventoryPath string
+	Ref           types.ManagedObjectReference
+	UUID          string
+	IP            string
+	Nets          []types.GuestNicInfo
+	GuestFamily   string
+	GuestID       string
+	ToolsRunning  bool
+	PowerState    types.VirtualMachinePowerState
+	BootTime      time.Time
+	Modified      time.Time
+	Template      bool
+	Annotation    string
+}
+
+// vmProperties are the properties read for every VM.
+var vmProperties = []string{
+	"name",
+	"guest.ipAddress",
+	"guest.net",
+	"guest.toolsRunningStatus",
+	"guest.guestFamily",
+	"summary.config",
+	"runtime.powerState",
+	"runtime.bootTime",
+	"config.modified",
+	"config.annotation",
+}
+
+// ListHosts returns the hosts in the named datacenters, or in every
+// datacenter if none are named.
+func ListHosts(ctx context.Context, c *vim25.Client, datacenters ...string) ([]HostInfo, error) {
+	f := find.NewFinder(c, true)
+
+	dcs, err := findDatacenters(ctx, f, datacenters)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []HostInfo
+	for _, dc := range dcs {
+		f.SetDatacenter(dc)
+
+		hosts, err := f.HostSystemList(ctx, "*")
+		if err != nil {
+			// a datacenter without hosts is fine
+			if _, ok := err.(*find.NotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, h := range hosts {
+			info := HostInfo{
+				Name:          h.Name(),
+				Datacenter:    dc.Name(),
+				InventoryPath: h.InventoryPath,
+				Ref:           h.Reference(),
+				IP:            h.Name(),
+			}
+
+			// hosts added by IP are named by it, so the name is a fair
+			// fallback
+			if ips, err := h.ManagementIPs(ctx); err == nil && len(ips) > 0 {
+				info.IP = ips[0].String()
+			}
+
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+// findDatacenters returns the named datacenters, or every datacenter if
+// none are named.
+func findDatacenters(ctx context.Context, f *find.Finder, names []string) ([]*object.Datacenter, error) {
+	if len(names) == 0 {
+		return f.DatacenterList(ctx, "*")
+	}
+
+	var dcs []*object.Datacenter
+	for _, name := range names {
+		dc, err := f.Datacenter(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		dcs = append(dcs, dc)
+	}
+
+	return dcs, nil
+}
+
+// ClusterHosts returns the hosts of the cluster called name, looked for in
+// the named datacenters or in every datacenter. It is an error if there is
+// no such cluster.
+func ClusterHosts(ctx context.Context, c *vim25.Client, name string, datacenters ...string) (map[types.ManagedObjectReference]bool, error) {
+	f := find.NewFinder(c, true)
+
+	dcs, err := findDatacenters(ctx, f, datacenters)
+	if err != nil {
+		return nil, err
+	}
+
+	var found bool
+	hosts := map[types.ManagedObjectReference]bool{}
+	for _, dc := range dcs {
+		f.SetDatacenter(dc)
+
+		clusters, err := f.ClusterComputeResourceList(ctx, name)
+		if err != nil {
+			if _, ok := err.(*find.NotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, cl := range clusters {
+			found = true
+
+			hs, err := f.HostSystemList(ctx, cl.InventoryPath+"/*")
+			if err != nil {
+				// a cluster without hosts is fine
+				if _, ok := err.(*find.NotFoundError); ok {
+					continue
+				}
+				return nil, err
+			}
+
+			for _, h := range hs {
+				hosts[h.Reference()] = true
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("cluster %q not found", name)
+	}
+
+	return hosts, nil
+}
+
+// PoolVMs returns the VMs in the resource pool called name and its child
+// pools, looked for in the named datacenters or in every datacenter. It is
+// an error if there is no such pool.
+func PoolVMs(ctx context.Context, c *vim25.Client, name string, datacenters ...string) (map[types.ManagedObjectReference]bool, error) {
+	f := find.NewFinder(c, true)
+
+	dcs, err := findDatacenters(ctx, f, datacenters)
+	if err != nil {
+		return nil, err
+	}
+
+	var found bool
+	vms := map[types.ManagedObjectReference]bool{}
+	for _, dc := range dcs {
+		f.SetDatacenter(dc)
+
+		pools, err := f.ResourcePoolList(ctx, name)
+		if err != nil {
+			if _, ok := err.(*find.NotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, p := range pools {
+			found = true
+
+			refs, err := containerVMs(ctx, c, p.Reference(), true)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ref := range refs {
+				vms[ref] = true
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("resource pool %q not found", name)
+	}
+
+	return vms, nil
+}
+
+// FolderVMs returns the VMs in the folder called name, and in its
+// subfolders if recursive is set, looked for in the named datacenters or in
+// every datacenter. It is an error if there is no such folder.
+func FolderVMs(ctx context.Context, c *vim25.Client, name string, recursive bool, datacenters ...string) (map[types.ManagedObjectReference]bool, error) {
+	f := find.NewFinder(c, true)
+
+	dcs, err := findDatacenters(ctx, f, datacenters)
+	if err != nil {
+		return nil, err
+	}
+
+	var found bool
+	vms := map[types.ManagedObjectReference]bool{}
+	for _, dc := range dcs {
+		f.SetDatacenter(dc)
+
+		folders, err := f.FolderList(ctx, name)
+		if err != nil {
+			if _, ok := err.(*find.NotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, fo := range folders {
+			found = true
+
+			refs, err := containerVMs(ctx, c, fo.Reference(), recursive)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ref := range refs {
+				vms[ref] = true
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("folder %q not found", name)
+	}
+
+	return vms, nil
+}
+
+// containerVMs returns the VMs in the pool or folder container, and in
+// the containers nested in it if recursive is set.
+func containerVMs(ctx context.Context, c *vim25.Client, container types.ManagedObjectReference, recursive bool) ([]types.ManagedObjectReference, error) {
+	v, err := view.NewManager(c).CreateContainerView(ctx, container, []string{"VirtualMachine"}, recursive)
+	if err != nil {
+		return nil, err
+	}
+	defer v.Destroy(context.Background())
+
+	var data []mo.VirtualMachine
+	if err := v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"name"}, &data); err != nil {
+		return nil, err
+	}
+
+	refs := make([]types.ManagedObjectReference, len(data))
+	for i, vm := range data {
+		refs[i] = vm.Self
+	}
+
+	return refs, nil
+}
+
+// ListPoweredOnVMs returns the powered on VMs running on host. Templates
+// are never powered on so they are never returned.
+func ListPoweredOnVMs(ctx context.Context, c *vim25.Client, host HostInfo) ([]VMInfo, error) {
+	vms, err := ListVMs(ctx, c, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var on []VMInfo
+	for _, vm := range vms {
+		if vm.PowerState == types.VirtualMachinePowerStatePoweredOn {
+			on = append(on, vm)
+		}
+	}
+
+	return on, nil
+}
+
+// ListVMs returns every VM registered on host, including templates. The
+// properties of all the host's VMs are read in a single round trip.
+func ListVMs(ctx context.Context, c *vim25.Client, host HostInfo) ([]VMInfo, error) {
+	f := find.NewFinder(c, true)
+
+	vms, err := f.VirtualMachineList(ctx, host.InventoryPath+"/*")
+	if err != nil {
+		// a host without vms is fine
+		if _, ok := err.(*find.NotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if len(vms) == 0 {
+		return nil, nil
+	}
+
+	refs := make([]types.ManagedObjectReference, len(vms))
+	paths := map[string]string{}
+	for i, vm := range vms {
+		refs[i] = vm.Reference()
+		paths[refs[i].Value] = vm.InventoryPath
+	}
+
+	var data []mo.VirtualMachine
+	pc := property.DefaultCollector(c)
+	if err := pc.Retrieve(ctx, refs, vmProperties, &data); err != nil {
+		return nil, err
+	}
+
+	var infos []VMInfo
+	for _, vm := range data {
+		info := newVMInfo(paths[vm.Self.Value], vm)
+		info.Host = host.Name
+		info.HostRef = host.Ref
+		info.Datacenter = host.Datacenter
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func newVMInfo(path string, data mo.VirtualMachine) VMInfo {
+	info := VMInfo{
+		Name:          data.Name,
+		InventoryPath: path,
+		Ref:           data.Reference(),
+		UUID:          data.Summary.Config.InstanceUuid,
+		GuestID:       data.Summary.Config.GuestId,
+		PowerState:    data.Runtime.PowerState,
+		Template:      data.Summary.Config.Template,
+	}
+
+	if data.Runtime.BootTime != nil {
+		info.BootTime = *data.Runtime.BootTime
+	}
+
+	if data.Config != nil {
+		info.Modified = data.Config.Modified
+		info.Annotation = data.Config.Annotation
+	}
+
+	if data.Guest != nil {
+		info.IP = data.Guest.IpAddress
+		info.Nets = data.Guest.Net
+		info.ToolsRunning = data.Guest.ToolsRunningStatus == string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+		info.GuestFamily = data.Guest.GuestFamily
+	}
+
+	return info
+}
+
+// FindVM returns the VM with the instance UUID uuid, which unlike a
+// managed object reference survives a --cache-file.
+func FindVM(ctx context.Context, c *vim25.Client, uuid string) (*object.VirtualMachine, error) {
+	instanceUUID := true
+	ref, err := object.NewSearchIndex(c).FindByUuid(ctx, nil, uuid, true, &instanceUUID)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("vm %s not found", uuid)
+	}
+
+	return object.NewVirtualMachine(c, ref.Reference()), nil
+}
+
+// WaitForIP waits up to timeout for vm's guest.ipAddress to be set, which
+// only happens once VMware Tools is running in the guest.
+func WaitForIP(ctx context.Context, c *vim25.Client, vm VMInfo, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return object.NewVirtualMachine(c, vm.Ref).WaitForIP(ctx)
+}
@@ -0,0 +1,118 @@
+package inventory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestListSimulator(t *testing.T) {
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+		hosts, err := ListHosts(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := find.NewFinder(c, true).HostSystemList(ctx, "*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hosts) != len(want) {
+			t.Fatalf("ListHosts returned %d hosts, want %d", len(hosts), len(want))
+		}
+
+		var vms []VMInfo
+		for _, h := range hosts {
+			if h.IP == "" {
+				t.Errorf("host %s has no IP", h.Name)
+			}
+
+			on, err := ListPoweredOnVMs(ctx, c, h)
+			if err != nil {
+				t.Fatalf("host %s: %s", h.Name, err)
+			}
+			vms = append(vms, on...)
+		}
+
+		all, err := find.NewFinder(c, true).VirtualMachineList(ctx, "*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vms) != len(all) {
+			t.Errorf("found %d powered on vms, want %d", len(vms), len(all))
+		}
+
+		for _, vm := range vms {
+			if vm.Name == "" || vm.UUID == "" {
+				t.Errorf("vm %+v has no name or uuid", vm)
+			}
+			if vm.PowerState != types.VirtualMachinePowerStatePoweredOn {
+				t.Errorf("vm %s: power state = %s, want poweredOn", vm.Name, vm.PowerState)
+			}
+		}
+	})
+}
+
+// benchmarkHost runs f against the only host of a simulator with vms vms
+// on it.
+func benchmarkHost(b *testing.B, vms int, f func(ctx context.Context, c *vim25.Client, h HostInfo) error) {
+	model := simulator.VPX()
+	model.Cluster = 0
+	model.Machine = vms
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		b.Fatal(err)
+	}
+
+	err := model.Run(func(ctx context.Context, c *vim25.Client) error {
+		hosts, err := ListHosts(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := f(ctx, c, hosts[0]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkListVMs reads the properties of 500 vms in one round trip.
+func BenchmarkListVMs(b *testing.B) {
+	benchmarkHost(b, 500, func(ctx context.Context, c *vim25.Client, h HostInfo) error {
+		_, err := ListVMs(ctx, c, h)
+		return err
+	})
+}
+
+// BenchmarkListVMsPerVM reads the properties of 500 vms a round trip each,
+// as discovery used to.
+func BenchmarkListVMsPerVM(b *testing.B) {
+	benchmarkHost(b, 500, func(ctx context.Context, c *vim25.Client, h HostInfo) error {
+		vms, err := find.NewFinder(c, true).VirtualMachineList(ctx, h.InventoryPath+"/*")
+		if err != nil {
+			return err
+		}
+
+		for _, vm := range vms {
+			var data mo.VirtualMachine
+			if err := vm.Properties(ctx, vm.Reference(), vmProperties, &data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
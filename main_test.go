@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestGetEnvGOVC(t *testing.T) {
+	tests := []struct {
+		govmomi, govc string
+		want          string
+	}{
+		{"", "", "default"},
+		{"", "https://govc@vcenter/sdk", "https://govc@vcenter/sdk"},
+		{"https://govmomi@vcenter/sdk", "", "https://govmomi@vcenter/sdk"},
+		{"https://govmomi@vcenter/sdk", "https://govc@vcenter/sdk", "https://govmomi@vcenter/sdk"},
+	}
+	for _, tt := range tests {
+		t.Setenv(envURL, tt.govmomi)
+		t.Setenv("GOVC_URL", tt.govc)
+
+		if got := getEnvString(envURL, "default"); got != tt.want {
+			t.Errorf("GOVMOMI_URL=%q GOVC_URL=%q: url = %q, want %q", tt.govmomi, tt.govc, got, tt.want)
+		}
+	}
+
+	t.Setenv(envInsecure, "")
+	t.Setenv("GOVC_INSECURE", "1")
+	if !getEnvBool(envInsecure, false) {
+		t.Error("GOVC_INSECURE=1: insecure = false, want true")
+	}
+
+	// only the GOVMOMI_* variables have govc equivalents
+	t.Setenv(envProfilesPath, "")
+	t.Setenv("GOVC_PROFILES_PATH", "/govc")
+	if got := getEnvString(envProfilesPath, "inspec"); got != "inspec" {
+		t.Errorf("profiles path = %q, want the default", got)
+	}
+}
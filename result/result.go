@@ -0,0 +1,115 @@
+// Package result models the output of InSpec's json reporter.
+package result
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Result statuses
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+	StatusWaived  = "waived"
+)
+
+// Result is an InSpec json report: the outcome of running one or more
+// profiles against a target.
+type Result struct {
+	Version    string     `json:"version"`
+	Platform   Platform   `json:"platform"`
+	Profiles   []Profile  `json:"profiles"`
+	Statistics Statistics `json:"statistics"`
+}
+
+// Platform is what InSpec found the target to be.
+type Platform struct {
+	Name     string `json:"name"`
+	Release  string `json:"release"`
+	TargetID string `json:"target_id,omitempty"`
+}
+
+// Statistics are the totals of a run.
+type Statistics struct {
+	Duration float64 `json:"duration"`
+}
+
+// Profile is a profile that was run, with its controls.
+type Profile struct {
+	Name     string    `json:"name"`
+	Title    string    `json:"title,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Controls []Control `json:"controls"`
+}
+
+// Control is a control of a profile and the results of its tests.
+type Control struct {
+	ID      string          `json:"id"`
+	Title   string          `json:"title"`
+	Desc    string          `json:"desc,omitempty"`
+	Impact  float64         `json:"impact"`
+	Tags    json.RawMessage `json:"tags,omitempty"`
+	Results []TestResult    `json:"results"`
+
+	WaiverData *WaiverData `json:"waiver_data,omitempty"`
+}
+
+// WaiverData is how a waiver file applied to a control. InSpec writes an
+// empty one for controls without a waiver. A waiver that has expired still
+// shows up, with a Message saying so, but doesn't waive anything.
+type WaiverData struct {
+	Justification      string `json:"justification,omitempty"`
+	Run                bool   `json:"run"`
+	SkippedDueToWaiver bool   `json:"skipped_due_to_waiver"`
+	Message            string `json:"message,omitempty"`
+}
+
+// TestResult is the outcome of a single test of a control.
+type TestResult struct {
+	Status     string  `json:"status"`
+	CodeDesc   string  `json:"code_desc"`
+	RunTime    float64 `json:"run_time"`
+	Message    string  `json:"message,omitempty"`
+	SkipReason string  `json:"skip_message,omitempty"`
+}
+
+// Status rolls up the results of c the way InSpec does: any failure fails
+// the control, and it is skipped only if every result was. A control under
+// a waiver is waived whatever its results, whether the waiver kept it from
+// running or not.
+func (c Control) Status() string {
+	if c.Waived() {
+		return StatusWaived
+	}
+
+	status := StatusSkipped
+	for _, r := range c.Results {
+		switch r.Status {
+		case StatusFailed:
+			return StatusFailed
+		case StatusPassed:
+			status = StatusPassed
+		}
+	}
+
+	return status
+}
+
+// Waived reports whether a waiver that hasn't expired applies to c.
+func (c Control) Waived() bool {
+	w := c.WaiverData
+	if w == nil {
+		return false
+	}
+
+	return w.SkippedDueToWaiver || w.Run && w.Message == ""
+}
+
+// ParseResult reads an InSpec json report from r.
+func ParseResult(r io.Reader) (Result, error) {
+	var res Result
+	err := json.NewDecoder(r).Decode(&res)
+
+	return res, err
+}
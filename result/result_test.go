@@ -0,0 +1,94 @@
+package result
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// parseFile parses the InSpec json report in file.
+func parseFile(t *testing.T, file string) Result {
+	t.Helper()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	res, err := ParseResult(f)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+
+	return res
+}
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		file     string
+		platform string
+		profile  string
+		statuses map[string]string
+	}{
+		{
+			file:     "testdata/linux-baseline.json",
+			platform: "ubuntu",
+			profile:  "linux-baseline",
+			statuses: map[string]string{"os-01": StatusPassed, "os-02": StatusFailed, "os-10": StatusSkipped},
+		},
+		{
+			file:     "testdata/waivers-example.json",
+			platform: "ubuntu",
+			profile:  "waivers-example",
+			statuses: map[string]string{"ssh-01": StatusWaived, "ssh-02": StatusWaived, "ssh-03": StatusPassed, "ssh-04": StatusFailed},
+		},
+	}
+	for _, tt := range tests {
+		res := parseFile(t, tt.file)
+
+		if res.Platform.Name != tt.platform {
+			t.Errorf("%s: platform = %q, want %q", tt.file, res.Platform.Name, tt.platform)
+		}
+		if len(res.Profiles) != 1 || res.Profiles[0].Name != tt.profile {
+			t.Fatalf("%s: profiles = %+v, want only %s", tt.file, res.Profiles, tt.profile)
+		}
+
+		got := map[string]string{}
+		for _, c := range res.Profiles[0].Controls {
+			got[c.ID] = c.Status()
+		}
+		if !reflect.DeepEqual(got, tt.statuses) {
+			t.Errorf("%s: statuses = %v, want %v", tt.file, got, tt.statuses)
+		}
+	}
+}
+
+func TestParseResultInvalid(t *testing.T) {
+	for _, in := range []string{"", "{", `{"profiles": {}}`} {
+		if _, err := ParseResult(bytes.NewBufferString(in)); err == nil {
+			t.Errorf("ParseResult(%q) succeeded", in)
+		}
+	}
+}
+
+func TestResultRoundTrip(t *testing.T) {
+	for _, file := range []string{"testdata/linux-baseline.json", "testdata/waivers-example.json"} {
+		in := parseFile(t, file)
+
+		b, err := json.Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := ParseResult(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("%s: round trip = %+v, want %+v", file, out, in)
+		}
+	}
+}
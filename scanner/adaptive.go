@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// adaptiveLimiter bounds how many scans run at once between min and max,
+// AIMD style: every window scans it halves the limit if more than
+// threshold of them failed, and otherwise raises it by one. This keeps a
+// struggling network or overloaded guests from being hammered into ever
+// more failures. A nil adaptiveLimiter doesn't limit anything.
+type adaptiveLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	min, max  int
+	window    int
+	threshold float64
+
+	limit    int
+	inFlight int
+	done     int
+	failed   int
+}
+
+// newAdaptiveLimiter returns a limiter starting at max.
+func newAdaptiveLimiter(min, max, window int, threshold float64) *adaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	if window < 1 {
+		window = 1
+	}
+
+	l := &adaptiveLimiter{min: min, max: max, window: window, threshold: threshold, limit: max}
+	l.cond = sync.NewCond(&l.mu)
+
+	return l
+}
+
+// Acquire waits until another scan may start, reporting false if ctx was
+// done first.
+func (l *adaptiveLimiter) Acquire(ctx context.Context) bool {
+	if l == nil {
+		return ctx.Err() == nil
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.inFlight >= l.limit && ctx.Err() == nil {
+		l.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	l.inFlight++
+
+	return true
+}
+
+// Release records the end of a scan, and whether it failed, adjusting the
+// limit once a window of scans is done.
+func (l *adaptiveLimiter) Release(failed bool) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.done++
+	if failed {
+		l.failed++
+	}
+
+	if l.done >= l.window {
+		rate := float64(l.failed) / float64(l.done)
+		limit := l.limit
+		if rate > l.threshold {
+			limit = max(l.min, l.limit/2)
+		} else if l.limit < l.max {
+			limit = l.limit + 1
+		}
+
+		if limit != l.limit {
+			slog.Info("changing concurrency", "from", l.limit, "to", limit, "failure_rate", rate)
+			l.limit = limit
+		}
+		l.done, l.failed = 0, 0
+	}
+
+	l.cond.Broadcast()
+}
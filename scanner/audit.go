@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditSchemaVersion is the version of the --audit-log event format. Bump it
+// when a field changes meaning or goes away; adding fields doesn't need it.
+const auditSchemaVersion = 1
+
+// Audit events
+const (
+	auditRunStarted  = "run_started"
+	auditConnected   = "connected"
+	auditScanStarted = "scan_started"
+	auditScanDone    = "scan_finished"
+	auditError       = "error"
+	auditRunDone     = "run_finished"
+)
+
+// auditEvent is a line of the --audit-log. Field names are part of the
+// format, so don't rename them.
+type auditEvent struct {
+	SchemaVersion int       `json:"schema_version"`
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+
+	VCenter string `json:"vcenter,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	Target   string     `json:"target,omitempty"`
+	IP       string     `json:"ip,omitempty"`
+	Profile  string     `json:"profile,omitempty"`
+	Stage    string     `json:"stage,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	ExitCode *int       `json:"exit_code,omitempty"`
+	Error    string     `json:"error,omitempty"`
+
+	Passed  *int `json:"passed,omitempty"`
+	Failed  *int `json:"failed,omitempty"`
+	Skipped *int `json:"skipped,omitempty"`
+	Waived  *int `json:"waived,omitempty"`
+
+	Totals *JSONTotals `json:"totals,omitempty"`
+}
+
+// auditLog appends events to a JSONL file. A nil auditLog drops them, so
+// callers don't have to check whether --audit-log is set.
+type auditLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// openAuditLog opens path for appending, creating it if needed.
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Log appends e, stamped with the schema version and, if it has none, the
+// current time. Failures are logged but don't stop the run.
+func (a *auditLog) Log(e auditEvent) {
+	if a == nil {
+		return
+	}
+
+	e.SchemaVersion = auditSchemaVersion
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.enc.Encode(e); err != nil {
+		slog.Warn("can't write audit log", "path", a.f.Name(), "err", err)
+	}
+}
+
+// Close syncs the log to disk and closes it.
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+
+	return a.f.Close()
+}
+
+// auditRunner records the start and end of every scan in the audit log.
+type auditRunner struct {
+	Runner
+	a *auditLog
+}
+
+func (r auditRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	started := time.Now().UTC()
+	r.a.Log(auditEvent{Event: auditScanStarted, Time: started, Target: t.Name, IP: targetHost(t), Profile: profile})
+
+	res, err := r.Runner.Run(ctx, t, profile)
+
+	finished := time.Now().UTC()
+	e := auditEvent{
+		Event:    auditScanDone,
+		Time:     finished,
+		Target:   t.Name,
+		IP:       targetHost(t),
+		Profile:  profile,
+		Started:  &started,
+		Finished: &finished,
+		ExitCode: &res.ExitCode,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if s, serr := readSummary(t.Name, reportFile(t, "json")); serr == nil {
+		e.Passed, e.Failed, e.Skipped, e.Waived = &s.Passed, &s.Failed, &s.Skipped, &s.Waived
+	}
+	r.a.Log(e)
+
+	return res, err
+}
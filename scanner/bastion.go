@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+)
+
+// Bastion is an SSH jump host for the guests in CIDR, or for any guest if
+// it has none.
+type Bastion struct {
+	CIDR string `yaml:"cidr"`
+	Host string `yaml:"host"`
+	User string `yaml:"user"`
+	Port int    `yaml:"port"`
+
+	network *net.IPNet
+}
+
+// compileBastions checks bastions and parses their CIDRs.
+func compileBastions(bastions []Bastion) ([]Bastion, error) {
+	out := make([]Bastion, len(bastions))
+	for i, b := range bastions {
+		if b.Host == "" {
+			return nil, fmt.Errorf("bastion %d has no host", i+1)
+		}
+
+		if b.CIDR != "" {
+			var err error
+			if _, b.network, err = net.ParseCIDR(b.CIDR); err != nil {
+				return nil, fmt.Errorf("bastion %s: %s", b.Host, err)
+			}
+		}
+
+		out[i] = b
+	}
+
+	return out, nil
+}
+
+// bastionFor returns the first of bastions whose subnet holds ip.
+func bastionFor(bastions []Bastion, ip string) (Bastion, bool) {
+	addr := net.ParseIP(ip)
+	for _, b := range bastions {
+		if b.network == nil || addr != nil && b.network.Contains(addr) {
+			return b, true
+		}
+	}
+
+	return Bastion{}, false
+}
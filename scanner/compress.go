@@ -0,0 +1,121 @@
+package scanner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// gzipExt ends the names of gzipped files, which are read and written
+// compressed by their name alone.
+const gzipExt = ".gz"
+
+// gzipReadCloser closes both the gzip reader and the file under it.
+type gzipReadCloser struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (r gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.f.Close()
+}
+
+// openMaybeGzip opens path for reading, decompressing it if its name ends
+// in .gz.
+func openMaybeGzip(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, gzipExt) {
+		return f, nil
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return gzipReadCloser{Reader: zr, f: f}, nil
+}
+
+// readReportFile reads the report at path, or at path.gz if it was
+// compressed by --compress.
+func readReportFile(path string) ([]byte, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) && !strings.HasSuffix(path, gzipExt) {
+		if _, err := os.Stat(path + gzipExt); err == nil {
+			path += gzipExt
+		}
+	}
+
+	r, err := openMaybeGzip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// gzipBytes compresses b.
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compressFile replaces the file at path with path.gz.
+func compressFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	z, err := gzipBytes(b)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path+gzipExt, z); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// compressReports gzips the report files of every target scanned. Missing
+// reports are left to the summary to complain about.
+func compressReports(targets []TargetConfig) {
+	for _, t := range targets {
+		if t.Skip != "" {
+			continue
+		}
+
+		for format := range t.Reporter {
+			file := reportFile(t, format)
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				continue
+			}
+
+			if err := compressFile(file); err != nil {
+				slog.Warn("can't compress report", "target", t.Name, "path", file, "err", err)
+			}
+		}
+	}
+}
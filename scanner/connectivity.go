@@ -0,0 +1,116 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// dialTimeout bounds each --check-connectivity dial.
+const dialTimeout = 5 * time.Second
+
+// reachability is the outcome of dialing a target.
+type reachability struct {
+	Target  string
+	Address string
+	Err     error
+}
+
+// targetPort returns the port inspec connects to t on, or 0 for targets it
+// doesn't connect to, like those scanned through guest operations or the
+// clone of a template.
+func targetPort(t TargetConfig) int {
+	if t.VM.Template {
+		return 0
+	}
+	if t.Port != 0 {
+		return t.Port
+	}
+
+	switch targetTransport(t) {
+	case transportSSH:
+		return 22
+	case transportWinRM:
+		if t.SSL {
+			return 5986
+		}
+		return 5985
+	case transportVMware:
+		return 443
+	}
+
+	return 0
+}
+
+// checkConnectivity dials the port inspec would connect to on every
+// target, concurrency at a time, and returns how they fared in the order
+// of targets.
+func checkConnectivity(ctx context.Context, targets []TargetConfig, concurrency int) []reachability {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var d net.Dialer
+	results := make([]reachability, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, t := range targets {
+		port := targetPort(t)
+		if t.Skip != "" || port == 0 {
+			continue
+		}
+
+		results[i] = reachability{Target: t.Name, Address: net.JoinHostPort(targetHost(t), strconv.Itoa(port))}
+
+		wg.Add(1)
+		go func(r *reachability) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+			defer cancel()
+
+			conn, err := d.DialContext(ctx, "tcp", r.Address)
+			if err != nil {
+				r.Err = err
+				return
+			}
+			conn.Close()
+		}(&results[i])
+	}
+
+	wg.Wait()
+
+	var checked []reachability
+	for _, r := range results {
+		if r.Address != "" {
+			checked = append(checked, r)
+		}
+	}
+
+	return checked
+}
+
+// printReachability prints the outcome of checkConnectivity as a table.
+func printReachability(results []reachability) {
+	fmt.Fprintf(humanOut, "\nConnectivity\n\n")
+	w := new(tabwriter.Writer)
+	w.Init(humanOut, 0, 8, 1, '\t', 0)
+
+	fmt.Fprintf(w, "TARGET\tADDRESS\tSTATUS\n")
+	for _, r := range results {
+		status := "reachable"
+		if r.Err != nil {
+			status = "unreachable: " + r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Target, r.Address, status)
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,154 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"gopkg.in/yaml.v2"
+)
+
+// Credential is the login used to scan a guest.
+type Credential struct {
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	SSHKeyPath   string `yaml:"ssh_key_path"`
+	SudoPassword string `yaml:"sudo_password"`
+
+	// Source says where the credential was found, for --plan
+	Source string `yaml:"-"`
+}
+
+// credentialRule maps a VM name glob or a guest IP CIDR to a credential.
+type credentialRule struct {
+	Name       string `yaml:"name"`
+	CIDR       string `yaml:"cidr"`
+	Credential `yaml:",inline"`
+
+	network *net.IPNet
+}
+
+// CredentialStore resolves guest and ESXi host credentials from a YAML
+// file like:
+//
+//	default:
+//	  user: root
+//	  password: secret
+//	credentials:
+//	  - name: "web-*"
+//	    user: deploy
+//	    ssh_key_path: /home/deploy/.ssh/id_rsa
+//	    sudo_password: secret
+//	  - cidr: 10.20.0.0/16
+//	    user: admin
+//	    password: secret
+//	guest_families:
+//	  windowsGuest:
+//	    user: Administrator
+//	    password: secret
+//
+// The first rule whose name matches wins, else the first whose CIDR holds
+// the IP, else the entry for the guest family, else the default.
+type CredentialStore struct {
+	Default       *Credential           `yaml:"default"`
+	Rules         []credentialRule      `yaml:"credentials"`
+	GuestFamilies map[string]Credential `yaml:"guest_families"`
+}
+
+// LoadCredentialStore reads a CredentialStore from a YAML file.
+func LoadCredentialStore(file string) (*CredentialStore, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var s CredentialStore
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	if err := s.compile(file); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// compile checks the rules of s, read from file, and parses their CIDRs.
+func (s *CredentialStore) compile(file string) error {
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		if r.Name == "" && r.CIDR == "" {
+			return fmt.Errorf("%s: credential %d has neither name nor cidr", file, i+1)
+		}
+
+		if r.CIDR != "" {
+			var err error
+			_, r.network, err = net.ParseCIDR(r.CIDR)
+			if err != nil {
+				return fmt.Errorf("%s: %s", file, err)
+			}
+		}
+
+		if r.Name != "" {
+			if _, err := path.Match(r.Name, ""); err != nil {
+				return fmt.Errorf("%s: bad name pattern %q: %s", file, r.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the credential for vm.
+func (s *CredentialStore) Lookup(vm inventory.VMInfo) (Credential, bool) {
+	return s.lookup(vm.Name, vm.IP, vm.GuestFamily)
+}
+
+// LookupHost returns the credential for the ESXi host h.
+func (s *CredentialStore) LookupHost(h inventory.HostInfo) (Credential, bool) {
+	return s.lookup(h.Name, h.IP, "")
+}
+
+// lookup returns the credential for the machine called name at addr, with
+// guest family family if it's a vm.
+func (s *CredentialStore) lookup(name, addr, family string) (Credential, bool) {
+	if s == nil {
+		return Credential{}, false
+	}
+
+	for _, r := range s.Rules {
+		if r.Name != "" {
+			if ok, _ := path.Match(r.Name, name); ok {
+				cred := r.Credential
+				cred.Source = "credentials name " + r.Name
+				return cred, true
+			}
+		}
+	}
+
+	if ip := net.ParseIP(addr); ip != nil {
+		for _, r := range s.Rules {
+			if r.network != nil && r.network.Contains(ip) {
+				cred := r.Credential
+				cred.Source = "credentials cidr " + r.CIDR
+				return cred, true
+			}
+		}
+	}
+
+	if cred, ok := s.GuestFamilies[family]; ok && family != "" {
+		cred.Source = "credentials guest family " + family
+		return cred, true
+	}
+
+	if s.Default != nil {
+		cred := *s.Default
+		cred.Source = "credentials default"
+		return cred, true
+	}
+
+	return Credential{}, false
+}
@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/gpeers/vmware-poc/inventory"
+)
+
+func TestCredentialStorePrecedence(t *testing.T) {
+	s := &CredentialStore{
+		Default: &Credential{User: "default"},
+		Rules: []credentialRule{
+			{CIDR: "10.1.0.0/16", Credential: Credential{User: "cidr"}},
+			{Name: "web-*", Credential: Credential{User: "name"}},
+		},
+		GuestFamilies: map[string]Credential{
+			"linuxGuest":   {User: "root"},
+			"windowsGuest": {User: "Administrator"},
+		},
+	}
+	if err := s.compile("credentials.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		vm     inventory.VMInfo
+		user   string
+		source string
+	}{
+		{inventory.VMInfo{Name: "web-1", IP: "10.1.0.5", GuestFamily: "linuxGuest"}, "name", "credentials name web-*"},
+		{inventory.VMInfo{Name: "db-1", IP: "10.1.0.6", GuestFamily: "linuxGuest"}, "cidr", "credentials cidr 10.1.0.0/16"},
+		{inventory.VMInfo{Name: "db-2", IP: "10.2.0.5", GuestFamily: "linuxGuest"}, "root", "credentials guest family linuxGuest"},
+		{inventory.VMInfo{Name: "ad-1", IP: "10.2.0.6", GuestFamily: "windowsGuest"}, "Administrator", "credentials guest family windowsGuest"},
+		{inventory.VMInfo{Name: "appliance-1", IP: "10.2.0.7", GuestFamily: "otherGuest"}, "default", "credentials default"},
+		{inventory.VMInfo{Name: "appliance-2"}, "default", "credentials default"},
+	}
+	for _, tt := range tests {
+		cred, ok := s.Lookup(tt.vm)
+		if !ok || cred.User != tt.user || cred.Source != tt.source {
+			t.Errorf("Lookup(%s) = %q from %q, %v, want %q from %q", tt.vm.Name, cred.User, cred.Source, ok, tt.user, tt.source)
+		}
+	}
+
+	if _, ok := (&CredentialStore{}).Lookup(tests[0].vm); ok {
+		t.Error("empty store has a credential")
+	}
+	if _, ok := (*CredentialStore)(nil).Lookup(tests[0].vm); ok {
+		t.Error("nil store has a credential")
+	}
+}
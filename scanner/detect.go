@@ -0,0 +1,185 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// detection is what inspec detect found out about a target.
+type detection struct {
+	Name     string   `json:"name"`
+	Families []string `json:"families"`
+	Release  string   `json:"release"`
+	Arch     string   `json:"arch"`
+}
+
+// detectGuestFamilies map inspec platform families to vSphere guest
+// families, for profile maps keyed by the latter.
+var detectGuestFamilies = map[string]string{
+	"windows": windowsGuestFamily,
+	"linux":   "linuxGuest",
+	"darwin":  "darwinGuestFamily",
+	"solaris": "solarisGuest",
+}
+
+// Detect runs inspec detect against t.
+func (r CLIRunner) Detect(ctx context.Context, t TargetConfig) (detection, error) {
+	var d detection
+
+	conf, err := json.Marshal(t)
+	if err != nil {
+		return d, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	cmd := newInspecCmd(ctx, r.Bin, []string{"detect", "--format", "json", "--json-config=-"})
+	cmd.Stdin = bytes.NewBuffer(conf)
+
+	var out bytes.Buffer
+	stderr := &tailBuffer{max: maxStderr}
+	cmd.Stdout = &out
+	cmd.Stderr = stderr
+
+	slog.Debug("detecting platform", "target", t.Name, "ip", t.Target)
+	if err := cmd.Run(); err != nil {
+		return d, fmt.Errorf("%s: %s", err, stderrTail(stderr.String()))
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		return d, fmt.Errorf("can't parse inspec detect output: %s", err)
+	}
+
+	return d, nil
+}
+
+// detectedProfile returns the profile for a target detected as d: the one
+// mapped to its platform name or to one of its families, else the one
+// mapped to the matching vSphere guest family.
+func detectedProfile(profiles map[string]string, d detection) (string, bool) {
+	if len(profiles) == 0 {
+		return vsphereProfile, true
+	}
+
+	keys := append([]string{d.Name}, d.Families...)
+	for _, k := range keys {
+		if p, ok := profiles[k]; ok {
+			return p, true
+		}
+	}
+
+	for _, f := range d.Families {
+		if p, ok := profiles[detectGuestFamilies[f]]; ok {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+// loadDetections reads the detections cached at path, by outputName of
+// their target. A missing cache is empty.
+func loadDetections(path string) (map[string]detection, error) {
+	detections := map[string]detection{}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return detections, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &detections); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return detections, nil
+}
+
+// writeDetections caches detections at path.
+func writeDetections(path string, detections map[string]detection) error {
+	b, err := json.MarshalIndent(detections, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b)
+}
+
+// autoDetect picks a profile for every target without one by running
+// inspec detect against it, concurrency at a time, unless detections
+// already has it. New detections are added to detections. Targets that
+// can't be detected are dropped as ScanErrors, and those without a profile
+// for their platform are dropped with a warning.
+func autoDetect(ctx context.Context, r CLIRunner, targets []TargetConfig, profiles map[string]string, detections map[string]detection, concurrency int) ([]TargetConfig, []ScanError) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	errs := make([]error, len(targets))
+
+	for i, t := range targets {
+		if t.Skip != "" || t.Profile != "" {
+			continue
+		}
+		if _, ok := detections[outputName(t)]; ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, t TargetConfig) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			d, err := r.Detect(ctx, t)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			mu.Lock()
+			detections[outputName(t)] = d
+			mu.Unlock()
+		}(i, t)
+	}
+
+	wg.Wait()
+
+	var keep []TargetConfig
+	var scanErrs []ScanError
+	for i, t := range targets {
+		if t.Skip != "" || t.Profile != "" {
+			keep = append(keep, t)
+			continue
+		}
+
+		if errs[i] != nil {
+			scanErrs = append(scanErrs, ScanError{Target: t.Name, Stage: stageDetect, Err: errs[i]})
+			continue
+		}
+
+		d := detections[outputName(t)]
+		p, ok := detectedProfile(profiles, d)
+		if !ok {
+			slog.Warn("no profile for detected platform, skipping", "target", t.Name, "platform", d.Name, "families", d.Families)
+			continue
+		}
+
+		slog.Info("detected platform", "target", t.Name, "platform", d.Name, "release", d.Release, "profile", p)
+		t.Profile = p
+		keep = append(keep, t)
+	}
+
+	return keep, scanErrs
+}
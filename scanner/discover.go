@@ -0,0 +1,724 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/session/cache"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// discoverOptions controls which vms discoverTargets turns into targets.
+type discoverOptions struct {
+	datacenters []string
+
+	creds    *CredentialStore
+	secrets  SecretProvider
+	profiles map[string]string
+	hosts    hostFilter
+	tags     *tagFilter
+
+	// vmNames are --vm-name globs, any of which selects a vm
+	vmNames []string
+
+	// excludeVMNames are --exclude-vm-name globs, any of which drops a vm
+	// whatever vmNames says
+	excludeVMNames []string
+
+	// skipSystemVMs drops the vms matching systemVMNames
+	skipSystemVMs bool
+
+	// cluster and resourcePool restrict the vms to one cluster or pool
+	cluster      string
+	resourcePool string
+
+	// folder restricts the vms to one folder, and its subfolders if
+	// folderRecursive is set
+	folder          string
+	folderRecursive bool
+
+	// discoveryWorkers is how many hosts are listed at once
+	discoveryWorkers int
+
+	// powerState is a --power-state value
+	powerState string
+
+	// profile, if set, is scanned on every vm whatever its guest
+	profile string
+
+	// transport is a --transport value
+	transport string
+
+	// targetNetwork is the CIDR guest IPs are picked from, if set
+	targetNetwork string
+
+	// onlyWithTools skips the vms VMware Tools isn't running in
+	onlyWithTools bool
+
+	// since, if set, skips the vms that haven't changed since
+	since time.Time
+
+	// autoDetect keeps the vms without a profile for their guest, for
+	// autoDetect to find one
+	autoDetect bool
+
+	// inventoryOnly returns every selected vm as a target, scannable or
+	// not, and no hosts
+	inventoryOnly bool
+
+	// scanTemplates keeps templates, which templateRunner scans through a
+	// clone
+	scanTemplates bool
+
+	// preferIPv6 picks guest IPv6 addresses over IPv4 ones
+	preferIPv6 bool
+
+	// winrmSSL makes winrm targets use https
+	winrmSSL bool
+
+	// sudo runs the checks on ssh targets with sudo, with sudoPassword
+	// unless their credential has one, and sudoOptions
+	sudo         bool
+	sudoPassword string
+	sudoOptions  string
+
+	// bastions are what ssh targets are reached through, the first whose
+	// subnet holds the guest IP
+	bastions []Bastion
+
+	// ports are the ports of the guests in their subnets, the first that
+	// holds the guest IP winning over port
+	ports []PortRule
+	port  int
+
+	// waitForIP is how long to wait for vms without a guest IP
+	waitForIP time.Duration
+
+	// hostLogin, if set, is the --hosts-file login of the standalone host
+	// being discovered, used to scan the host too
+	hostLogin *Credential
+
+	// esxiUser and esxiPassword log in to the hosts without a credential
+	// of their own, if there is a password
+	esxiUser     string
+	esxiPassword string
+
+	// logLevel is the InSpec log level of the targets
+	logLevel string
+}
+
+// discoverTargets walks the inventory and returns a TargetConfig for every
+// powered on vm with credentials and a profile. Failures to read a host or
+// vm are returned as ScanErrors; err is only set if the hosts can't be
+// listed at all.
+func discoverTargets(ctx context.Context, c *vim25.Client, opts discoverOptions) ([]TargetConfig, []ScanError, error) {
+	// resolve the cluster, pool and folder first, so a typo fails straight
+	// away
+	var clusterHosts, poolVMs, folderVMs map[types.ManagedObjectReference]bool
+	if opts.cluster != "" {
+		var err error
+		clusterHosts, err = inventory.ClusterHosts(ctx, c, opts.cluster, opts.datacenters...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.resourcePool != "" {
+		var err error
+		poolVMs, err = inventory.PoolVMs(ctx, c, opts.resourcePool, opts.datacenters...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.folder != "" {
+		var err error
+		folderVMs, err = inventory.FolderVMs(ctx, c, opts.folder, opts.folderRecursive, opts.datacenters...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	hosts, err := inventory.ListHosts(ctx, c, opts.datacenters...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slog.Info("found hosts", "count", len(hosts))
+
+	// hosts are listed concurrently, but their targets kept in host order
+	hostTargets := make([][]TargetConfig, len(hosts))
+	hostErrs := make([][]ScanError, len(hosts))
+	scope := inventoryScope{clusterHosts: clusterHosts, poolVMs: poolVMs, folderVMs: folderVMs}
+	sem := make(chan struct{}, max(opts.discoveryWorkers, 1))
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h inventory.HostInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			hostTargets[i], hostErrs[i] = discoverHost(ctx, c, opts, scope, h)
+		}(i, h)
+	}
+	wg.Wait()
+
+	var targets []TargetConfig
+	var scanErrs []ScanError
+	for i := range hosts {
+		targets = append(targets, hostTargets[i]...)
+		scanErrs = append(scanErrs, hostErrs[i]...)
+	}
+
+	return targets, scanErrs, nil
+}
+
+// inventoryScope is where --cluster, --resource-pool and --folder limit
+// discovery to; a nil set doesn't limit it.
+type inventoryScope struct {
+	clusterHosts, poolVMs, folderVMs map[types.ManagedObjectReference]bool
+}
+
+// discoverHost returns the targets on the ESXi host h, the host itself and
+// the vms on it that opts and scope select, and the errors met on the way.
+func discoverHost(ctx context.Context, c *vim25.Client, opts discoverOptions, scope inventoryScope, h inventory.HostInfo) ([]TargetConfig, []ScanError) {
+	var targets []TargetConfig
+	var scanErrs []ScanError
+
+	if !opts.hosts.Allowed(h) {
+		slog.Debug("skipping filtered host", "host", h.InventoryPath)
+		return targets, scanErrs
+	}
+
+	if scope.clusterHosts != nil && !scope.clusterHosts[h.Ref] {
+		slog.Debug("skipping host outside cluster", "host", h.InventoryPath, "cluster", opts.cluster)
+		return targets, scanErrs
+	}
+
+	// inspec doesn't run vs. vcenter, so hit every esxi host directly;
+	// the inventory lists vms only
+	if !opts.inventoryOnly {
+		cred, ok, err := hostCredentialFor(opts, h)
+		switch {
+		case err != nil:
+			scanErrs = append(scanErrs, ScanError{Target: h.InventoryPath, Stage: stageCredentials, Err: err})
+		case !ok:
+			slog.Warn("no credentials for host, skipping", "host", h.InventoryPath)
+		default:
+			targets = append(targets, newHostTarget(opts, h, cred))
+		}
+	}
+
+	vms, err := inventory.ListVMs(ctx, c, h)
+	if err != nil {
+		scanErrs = append(scanErrs, ScanError{Target: h.InventoryPath, Stage: stageDiscovery, Err: err})
+		return targets, scanErrs
+	}
+
+	if opts.tags != nil {
+		vms, err = opts.tags.Filter(ctx, vms)
+		if err != nil {
+			scanErrs = append(scanErrs, ScanError{Target: h.InventoryPath, Stage: stageDiscovery, Err: err})
+			return targets, scanErrs
+		}
+	}
+
+	slog.Info("found vms", "host", h.InventoryPath, "count", len(vms))
+
+	for _, vm := range vms {
+		slog.Debug("found vm", "host", h.InventoryPath, "vm", vm.Name, "ip", vm.IP, "powerState", vm.PowerState, "guestFamily", vm.GuestFamily)
+
+		if len(opts.vmNames) > 0 && !matchesAny(vm.Name, opts.vmNames) {
+			continue
+		}
+
+		if matchesAny(vm.Name, opts.excludeVMNames) {
+			slog.Debug("skipping excluded vm", "host", h.InventoryPath, "vm", vm.Name)
+			continue
+		}
+
+		if opts.skipSystemVMs && matchesAny(vm.Name, systemVMNames) {
+			slog.Debug("skipping system vm", "host", h.InventoryPath, "vm", vm.Name)
+			continue
+		}
+
+		if scope.poolVMs != nil && !scope.poolVMs[vm.Ref] {
+			continue
+		}
+
+		if scope.folderVMs != nil && !scope.folderVMs[vm.Ref] {
+			continue
+		}
+
+		// templates can't be powered on, so there's nothing to scan but a
+		// clone of them
+		if vm.Template && !opts.scanTemplates || !vm.Template && !powerStateMatches(opts.powerState, vm.PowerState) {
+			continue
+		}
+
+		if !opts.since.IsZero() && !changedSince(vm, opts.since) {
+			slog.Debug("vm unchanged, skipping", "host", h.InventoryPath, "vm", vm.Name, "bootTime", vm.BootTime, "modified", vm.Modified)
+			continue
+		}
+
+		// the inventory doesn't need anything a scan would
+		if opts.inventoryOnly {
+			targets = append(targets, TargetConfig{Name: vm.InventoryPath, VM: vm})
+			continue
+		}
+
+		// inspec can only reach running guests, but keep the others so
+		// reports list everything that was selected
+		if vm.PowerState != types.VirtualMachinePowerStatePoweredOn && !vm.Template {
+			slog.Info("vm is not powered on, skipping", "host", h.InventoryPath, "vm", vm.Name, "powerState", vm.PowerState)
+			targets = append(targets, TargetConfig{Name: vm.InventoryPath, Skip: skipNotPoweredOn, VM: vm})
+			continue
+		}
+
+		// without VMware Tools there's no IP to reach the guest on, so
+		// the scan would only fail
+		if opts.onlyWithTools && !vm.ToolsRunning && !vm.Template {
+			slog.Info("VMware Tools not running in vm, skipping", "host", h.InventoryPath, "vm", vm.Name)
+			targets = append(targets, TargetConfig{Name: vm.InventoryPath, Skip: skipNoTools, VM: vm})
+			continue
+		}
+
+		// guest.ipAddress may be link-local or on an isolated network, so
+		// prefer an address on --target-network, or a routable one
+		ip, err := pickTargetIP(vm.Nets, opts.targetNetwork, opts.preferIPv6)
+		switch {
+		case err == nil:
+			vm.IP = ip
+		case opts.targetNetwork != "" && opts.transport != TransportGuestOps && !vm.Template:
+			slog.Warn("vm has no IP in the target network, skipping", "host", h.InventoryPath, "vm", vm.Name, "network", opts.targetNetwork)
+			continue
+		}
+
+		// inspec can't connect without an IP, which vms only get once
+		// VMware Tools is up; guest operations don't need one, and the
+		// clones of templates get theirs when scanned
+		if strings.TrimSpace(vm.IP) == "" && opts.transport != TransportGuestOps && !vm.Template {
+			if opts.waitForIP <= 0 {
+				slog.Warn("vm has no guest IP, skipping", "host", h.InventoryPath, "vm", vm.Name)
+				continue
+			}
+
+			slog.Info("waiting for guest IP", "host", h.InventoryPath, "vm", vm.Name, "timeout", opts.waitForIP)
+			vm.IP, err = inventory.WaitForIP(ctx, c, vm, opts.waitForIP)
+			if err != nil || strings.TrimSpace(vm.IP) == "" {
+				slog.Warn("vm has no guest IP, skipping", "host", h.InventoryPath, "vm", vm.Name, "err", err)
+				continue
+			}
+		}
+
+		cred, ok, err := credentialFor(opts, vm)
+		if err != nil {
+			scanErrs = append(scanErrs, ScanError{Target: vm.InventoryPath, Stage: stageCredentials, Err: err})
+			continue
+		}
+		if !ok {
+			slog.Warn("no credentials for vm, skipping", "host", h.InventoryPath, "vm", vm.Name, "ip", vm.IP)
+			continue
+		}
+
+		profile, ok := profileFor(opts.profiles, vm)
+		if opts.profile != "" {
+			profile, ok = opts.profile, true
+		}
+		if !ok && opts.autoDetect {
+			// autoDetect picks the profile later
+			profile, ok = "", true
+		}
+		if !ok {
+			slog.Warn("no profile for guest family, skipping", "host", h.InventoryPath, "vm", vm.Name, "guestFamily", vm.GuestFamily)
+			continue
+		}
+
+		slog.Debug("adding target", "host", h.InventoryPath, "vm", vm.Name, "ip", vm.IP, "profile", profile)
+		targets = append(targets, newTarget(opts, vm, profile, cred))
+	}
+
+	return targets, scanErrs
+}
+
+// newTarget returns the target that scans vm with profile, logging in with
+// cred.
+func newTarget(opts discoverOptions, vm inventory.VMInfo, profile string, cred Credential) TargetConfig {
+	if opts.transport == TransportGuestOps {
+		// the vm is found by its uuid, and the guest logged in to with a
+		// password, whatever the guest
+		return TargetConfig{
+			Name:     vm.InventoryPath,
+			Target:   targetURI(TransportGuestOps, vm.UUID),
+			User:     cred.User,
+			Password: cred.Password,
+			LogLevel: opts.logLevel,
+			Profile:  profile,
+			VM:       vm,
+
+			CredentialSource: cred.Source,
+		}
+	}
+
+	transport := TransportForGuest(vm.GuestFamily)
+	t := TargetConfig{
+		Name:     vm.InventoryPath,
+		Target:   targetURI(transport, vm.IP),
+		User:     cred.User,
+		Insecure: true,
+		LogLevel: opts.logLevel,
+		Profile:  profile,
+		VM:       vm,
+
+		CredentialSource: cred.Source,
+	}
+
+	switch {
+	case transport == transportWinRM:
+		// winrm only does password auth, and has no sudo
+		t.Password = cred.Password
+		t.SSL = opts.winrmSSL
+	case cred.SSHKeyPath != "":
+		// don't hand inspec a password when it can use a key
+		t.KeyFiles = []string{cred.SSHKeyPath}
+		t.SudoPassword = cred.SudoPassword
+	default:
+		t.Password = cred.Password
+		t.SudoPassword = cred.SudoPassword
+	}
+
+	if opts.sudo && transport == transportSSH {
+		t.Sudo = true
+		t.SudoOptions = opts.sudoOptions
+		if t.SudoPassword == "" {
+			t.SudoPassword = opts.sudoPassword
+		}
+	}
+
+	if b, ok := bastionFor(opts.bastions, vm.IP); ok && transport == transportSSH {
+		t.BastionHost, t.BastionUser, t.BastionPort = b.Host, b.User, b.Port
+	}
+
+	t.Port = portFor(opts.ports, transport, vm.IP, opts.port)
+
+	return t
+}
+
+// credentialFor returns the credential for vm from the secret provider if
+// there is one, else from the --credentials file. A provider having none
+// is an error, so the vm is reported rather than silently left out.
+func credentialFor(opts discoverOptions, vm inventory.VMInfo) (Credential, bool, error) {
+	if opts.secrets == nil {
+		cred, ok := opts.creds.Lookup(vm)
+		return cred, ok, nil
+	}
+
+	cred, err := opts.secrets.Get(vm.Name, vm.IP, vm.GuestFamily)
+	if err != nil {
+		return Credential{}, false, err
+	}
+
+	return cred, true, nil
+}
+
+// newHostTarget returns the target that scans the ESXi host h with the
+// vSphere profile, logging in with cred.
+func newHostTarget(opts discoverOptions, h inventory.HostInfo, cred Credential) TargetConfig {
+	return TargetConfig{
+		Name:     h.InventoryPath,
+		Target:   targetURI(transportVMware, h.IP),
+		User:     cred.User,
+		Password: cred.Password,
+		Insecure: true,
+		LogLevel: opts.logLevel,
+		Profile:  vsphereProfile,
+		VM:       inventory.VMInfo{Name: h.Name, Host: h.Name, HostRef: h.Ref, InventoryPath: h.InventoryPath, IP: h.IP},
+
+		CredentialSource: cred.Source,
+	}
+}
+
+// hostCredentialFor returns the login for the ESXi host h: its --hosts-file
+// entry's, else from the secret provider if there is one, else from the
+// --credentials file, else from --esxi-user and --esxi-password if a
+// password was given. Only a secret provider having none is an error; the
+// host is skipped otherwise.
+func hostCredentialFor(opts discoverOptions, h inventory.HostInfo) (Credential, bool, error) {
+	if opts.hostLogin != nil {
+		return *opts.hostLogin, true, nil
+	}
+
+	// a provider having none falls through to the file and flags
+	var noCred error
+	if opts.secrets != nil {
+		cred, err := opts.secrets.Get(h.Name, h.IP, "")
+		if err == nil {
+			return cred, true, nil
+		}
+		if !errors.Is(err, errNoCredential) {
+			return Credential{}, false, err
+		}
+		noCred = err
+	}
+
+	if cred, ok := opts.creds.LookupHost(h); ok {
+		return cred, true, nil
+	}
+
+	if opts.esxiPassword == "" {
+		return Credential{}, false, noCred
+	}
+
+	return Credential{User: opts.esxiUser, Password: opts.esxiPassword, Source: "esxi-user"}, true, nil
+}
+
+// vcenterInfo identifies the vCenter the targets were discovered in.
+type vcenterInfo struct {
+	Host    string `json:"host"`
+	Version string `json:"version"`
+}
+
+// discover connects to vCenter, prints its VMs and discovers the targets
+// selected by opts. The session is only needed for discovery, so it is
+// closed again before discover returns.
+func (s *Scanner) discover(ctx context.Context, opts discoverOptions) (vcenterInfo, []TargetConfig, []ScanError, error) {
+	// cleanup still has to reach vCenter after an interrupt
+	cleanupCtx := context.Background()
+
+	var vc vcenterInfo
+
+	c, err := s.newClient(ctx)
+	if err != nil {
+		return vc, nil, nil, fmt.Errorf("can't connect to vCenter: %s", err)
+	}
+
+	// keep the session alive for the next run when it's cached
+	if !s.cfg.SessionCache || s.cfg.SessionToken != "" {
+		defer c.Logout(cleanupCtx)
+	}
+
+	info := c.ServiceContent.About
+	slog.Info("connected", "name", info.Name, "version", info.Version, "uuid", info.InstanceUuid)
+
+	vc = vcenterInfo{Host: c.URL().Host, Version: info.Version}
+
+	if err := preflight(ctx, c); err != nil {
+		return vc, nil, nil, fmt.Errorf("preflight failed: %s", err)
+	}
+
+	// Create view of VirtualMachine objects
+	m := view.NewManager(c.Client)
+
+	v, err := m.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+	if err != nil {
+		return vc, nil, nil, fmt.Errorf("can't create vm view: %s", err)
+	}
+
+	defer v.Destroy(cleanupCtx)
+
+	// Retrieve summary property for all machines
+	// Reference: http://pubs.vmware.com/vsphere-60/topic/com.vmware.wssdk.apiref.doc/vim.VirtualMachine.html
+	var vms []mo.VirtualMachine
+	err = v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"summary"}, &vms)
+	if err != nil {
+		return vc, nil, nil, fmt.Errorf("can't retrieve vms: %s", err)
+	}
+
+	// Print summary per vm (see also: govc/vm/info.go)
+	fmt.Fprintf(humanOut, "\nDatacenter VMs\n\n")
+	w := new(tabwriter.Writer)
+
+	// Format in tab-separated columns with a tab stop of 5.
+	w.Init(humanOut, 0, 8, 0, '\t', 0)
+
+	for _, vm := range vms {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", vm.Summary.Config.Name, vm.Summary.Config.GuestFullName, vm.Summary.Config.InstanceUuid)
+	}
+
+	w.Flush()
+
+	// get esxi hosts
+	var tagged *tagFilter
+	if len(s.cfg.Tags) > 0 {
+		rc, err := s.newRestClient(ctx, c)
+		if err != nil {
+			return vc, nil, nil, fmt.Errorf("can't log in to the vCenter REST API: %s", err)
+		}
+		defer rc.Logout(cleanupCtx)
+
+		tagged = newTagFilter(tags.NewManager(rc), s.cfg.Tags)
+	}
+
+	slog.Info("getting hosts")
+
+	opts.tags = tagged
+
+	targets, scanErrs, err := discoverTargets(ctx, c.Client, opts)
+	if err != nil {
+		return vc, nil, nil, fmt.Errorf("can't list hosts: %s", err)
+	}
+
+	return vc, targets, scanErrs, nil
+}
+
+// newRestClient logs in to the vCenter REST API, which the SOAP client
+// can't reach on its own (e.g. for tags).
+func (s *Scanner) newRestClient(ctx context.Context, c *govmomi.Client) (*rest.Client, error) {
+	if s.cfg.SessionToken != "" {
+		return nil, errors.New("the REST API needs a user and password, not a session token")
+	}
+
+	rc := rest.NewClient(c.Client)
+	if err := rc.Login(ctx, s.cfg.URL.User); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+// newClient connects and logs in to ESX or vCenter.
+func (s *Scanner) newClient(ctx context.Context) (*govmomi.Client, error) {
+	u := s.cfg.URL
+	if u == nil {
+		return nil, errors.New("no vCenter URL")
+	}
+
+	// Reuse a cached session if there is one, logging in only when needed
+	if s.cfg.SessionCache && s.cfg.SessionToken == "" {
+		cs := &cache.Session{
+			URL:      u,
+			Insecure: s.tlsInsecure(),
+		}
+
+		c := new(vim25.Client)
+		if err := cs.Login(ctx, c, s.configureSoap); err != nil {
+			return nil, err
+		}
+		limitAPI(c)
+
+		return &govmomi.Client{
+			Client:         c,
+			SessionManager: session.NewManager(c),
+		}, nil
+	}
+
+	// Connect and log in to ESX or vCenter
+	sc := soap.NewClient(u, s.tlsInsecure())
+	if err := s.configureSoap(sc); err != nil {
+		return nil, err
+	}
+
+	vc, err := vim25.NewClient(ctx, sc)
+	if err != nil {
+		return nil, err
+	}
+	limitAPI(vc)
+
+	c := &govmomi.Client{
+		Client:         vc,
+		SessionManager: session.NewManager(vc),
+	}
+
+	// Clone the session the token was acquired from, or log in
+	if s.cfg.SessionToken != "" {
+		err = c.SessionManager.CloneSession(ctx, s.cfg.SessionToken)
+	} else {
+		err = c.Login(ctx, u.User)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// tlsInsecure reports whether to skip verifying the vCenter certificate.
+// A pinned thumbprint is only checked when verification is on.
+func (s *Scanner) tlsInsecure() bool {
+	return s.cfg.Insecure && s.cfg.Thumbprint == ""
+}
+
+// configureSoap applies the connection settings to the SOAP client before
+// it connects.
+//
+// Certificate checks take the first of:
+//   - --thumbprint: the certificate must have this thumbprint
+//   - --insecure: the certificate isn't checked
+//   - --ca-cert: the certificate must be signed by one of these CAs
+//   - otherwise it must be signed by a system CA
+//
+// --client-cert is presented to vCenter whichever of these applies.
+func (s *Scanner) configureSoap(sc *soap.Client) error {
+	switch {
+	case s.cfg.Thumbprint != "":
+		if s.cfg.Insecure || s.cfg.CACert != "" {
+			slog.Warn("--thumbprint is set, ignoring --insecure and --ca-cert")
+		}
+		sc.SetThumbprint(sc.URL().Host, s.cfg.Thumbprint)
+	case s.cfg.CACert != "":
+		if s.cfg.Insecure {
+			slog.Warn("--insecure is set, ignoring --ca-cert", "ca-cert", s.cfg.CACert)
+		} else if err := sc.SetRootCAs(s.cfg.CACert); err != nil {
+			return fmt.Errorf("can't load CA bundle %s: %s", s.cfg.CACert, err)
+		}
+	}
+
+	if s.cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.ClientCert, s.cfg.ClientKey)
+		if err != nil {
+			return fmt.Errorf("can't load client certificate %s: %s", s.cfg.ClientCert, err)
+		}
+		sc.SetCertificate(cert)
+	}
+
+	s.tuneTransport(sc)
+
+	return s.setProxy(sc)
+}
+
+// tuneTransport keeps connections to the server open between API calls, so
+// the many round trips of discovery don't each pay for a new TLS
+// handshake. The TLS config of the transport is left alone.
+func (s *Scanner) tuneTransport(sc *soap.Client) {
+	t := sc.DefaultTransport()
+	t.MaxIdleConns = s.cfg.MaxIdleConns
+	t.MaxIdleConnsPerHost = s.cfg.MaxIdleConns
+	t.IdleConnTimeout = s.cfg.Keepalive
+	t.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: s.cfg.Keepalive}).DialContext
+}
+
+// setProxy routes sc through --proxy if it is set. Credentials in the proxy
+// URL are sent as basic auth. TLS settings for the server itself are left
+// alone.
+func (s *Scanner) setProxy(sc *soap.Client) error {
+	if s.cfg.Proxy == "" {
+		return nil
+	}
+
+	p, err := url.Parse(s.cfg.Proxy)
+	if err != nil {
+		return fmt.Errorf("bad proxy URL: %s", err)
+	}
+
+	sc.DefaultTransport().Proxy = http.ProxyURL(p)
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"gopkg.in/yaml.v2"
+)
+
+// standaloneHost names the endpoint of runs against --hosts-file, which
+// have no single vCenter.
+const standaloneHost = "standalone"
+
+// esxiHost is a standalone ESXi host, listed in a --hosts-file like:
+//
+//	hosts:
+//	  - url: esxi1.example.com
+//	    user: root
+//	    password: secret
+//	  - url: https://10.0.0.12/sdk
+//	    user: root
+//	    password: secret
+//	    thumbprint: "AB:CD:..."
+//
+// A thumbprint pins the host's certificate; otherwise -insecure, insecure
+// and -ca-cert apply as they do to vCenter.
+type esxiHost struct {
+	URL        string `yaml:"url"`
+	User       string `yaml:"user"`
+	Password   string `yaml:"password"`
+	Insecure   bool   `yaml:"insecure"`
+	Thumbprint string `yaml:"thumbprint"`
+}
+
+// loadHostsFile reads a --hosts-file.
+func loadHostsFile(file string) ([]esxiHost, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Hosts []esxiHost `yaml:"hosts"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	for i, h := range f.Hosts {
+		if h.URL == "" {
+			return nil, fmt.Errorf("%s: host %d has no url", file, i+1)
+		}
+	}
+
+	return f.Hosts, nil
+}
+
+// connectESXi logs in to a standalone ESXi host.
+func (s *Scanner) connectESXi(ctx context.Context, h esxiHost) (*govmomi.Client, error) {
+	u, err := soap.ParseURL(h.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.User != "" {
+		u.User = url.UserPassword(h.User, h.Password)
+	}
+
+	insecure := (h.Insecure || s.cfg.Insecure) && h.Thumbprint == ""
+
+	sc := soap.NewClient(u, insecure)
+	switch {
+	case h.Thumbprint != "":
+		sc.SetThumbprint(u.Host, h.Thumbprint)
+	case s.cfg.CACert != "" && !insecure:
+		if err := sc.SetRootCAs(s.cfg.CACert); err != nil {
+			return nil, fmt.Errorf("can't load CA bundle %s: %s", s.cfg.CACert, err)
+		}
+	}
+
+	s.tuneTransport(sc)
+	if err := s.setProxy(sc); err != nil {
+		return nil, err
+	}
+
+	vc, err := vim25.NewClient(ctx, sc)
+	if err != nil {
+		return nil, err
+	}
+	limitAPI(vc)
+
+	c := &govmomi.Client{
+		Client:         vc,
+		SessionManager: session.NewManager(vc),
+	}
+
+	if err := c.Login(ctx, u.User); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// discoverESXi discovers the targets on every standalone host in turn.
+// Each host has its own connection, and a host that can't be reached is
+// recorded as a failure without stopping the others.
+func (s *Scanner) discoverESXi(ctx context.Context, hosts []esxiHost, opts discoverOptions) ([]TargetConfig, []ScanError) {
+	// cleanup still has to reach the hosts after an interrupt
+	cleanupCtx := context.Background()
+
+	var targets []TargetConfig
+	var scanErrs []ScanError
+
+	for _, h := range hosts {
+		if ctx.Err() != nil {
+			break
+		}
+
+		c, err := s.connectESXi(ctx, h)
+		if err != nil {
+			slog.Warn("can't connect to ESXi host", "host", h.URL, "err", err)
+			scanErrs = append(scanErrs, ScanError{Target: h.URL, Stage: stageConnect, Err: err})
+			continue
+		}
+
+		slog.Info("connected", "host", h.URL, "version", c.ServiceContent.About.Version)
+
+		// the host is scanned with the login it was discovered with
+		hostOpts := opts
+		if h.User != "" {
+			hostOpts.hostLogin = &Credential{User: h.User, Password: h.Password, Source: "hosts-file"}
+		}
+
+		ts, errs, err := discoverTargets(ctx, c.Client, hostOpts)
+		c.Logout(cleanupCtx)
+		if err != nil {
+			scanErrs = append(scanErrs, ScanError{Target: h.URL, Stage: stageDiscovery, Err: err})
+			continue
+		}
+
+		targets = append(targets, ts...)
+		scanErrs = append(scanErrs, errs...)
+	}
+
+	return targets, scanErrs
+}
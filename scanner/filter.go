@@ -0,0 +1,111 @@
+package scanner
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/gpeers/vmware-poc/inventory"
+)
+
+// hostMatcher matches hosts by name, IP or inventory path glob.
+type hostMatcher []string
+
+// newHostMatcher compiles a comma-separated list of host patterns.
+func newHostMatcher(s string) (hostMatcher, error) {
+	var m hostMatcher
+
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad host pattern %q: %s", p, err)
+		}
+
+		m = append(m, p)
+	}
+
+	return m, nil
+}
+
+// Match reports whether h matches any of the patterns. Hosts added to
+// vCenter by IP are named by their IP, so an IP matches the name.
+func (m hostMatcher) Match(h inventory.HostInfo) bool {
+	for _, p := range m {
+		if ok, _ := path.Match(p, h.Name); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(p, h.InventoryPath); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostFilter decides which hosts are scanned. An empty include list
+// includes every host.
+type hostFilter struct {
+	include hostMatcher
+	exclude hostMatcher
+}
+
+// Allowed reports whether h should be scanned.
+func (f hostFilter) Allowed(h inventory.HostInfo) bool {
+	if len(f.include) > 0 && !f.include.Match(h) {
+		return false
+	}
+
+	return !f.exclude.Match(h)
+}
+
+// matchesAny reports whether name matches any of the glob patterns. Bad
+// patterns never match; check them with validPatterns first.
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validPatterns returns an error for the first malformed glob pattern.
+func validPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %s", p, err)
+		}
+	}
+
+	return nil
+}
+
+// systemVMNames are name globs of the VMs vSphere and NSX deploy for
+// themselves, which --skip-system-vms leaves out: the vSphere Cluster
+// Services agents and the NSX managers and edges.
+var systemVMNames = []string{"vCLS*", "nsx-*", "NSX-*", "NSX *"}
+
+// dedupeTargets returns targets with only the first of those sharing report
+// files, i.e. the same vm or host, logging the others.
+func dedupeTargets(targets []TargetConfig) []TargetConfig {
+	seen := map[string]string{}
+	var out []TargetConfig
+	for _, t := range targets {
+		name := outputName(t)
+		if first, ok := seen[name]; ok {
+			slog.Warn("dropping duplicate target", "target", t.Name, "duplicate of", first)
+			continue
+		}
+		seen[name] = t.Name
+		out = append(out, t)
+	}
+
+	return out
+}
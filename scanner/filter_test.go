@@ -0,0 +1,36 @@
+package scanner
+
+import "testing"
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     bool
+	}{
+		{"web-1", []string{"web-*"}, true},
+		{"db-1", []string{"web-*"}, false},
+		{"web-1", []string{"web-?"}, true},
+		{"web-10", []string{"web-?"}, false},
+		{"web-1", []string{"web-1"}, true},
+		{"web-12", []string{"web-1"}, false},
+		{"db-1", []string{"web-*", "db-*"}, true},
+		{"web-1", nil, false},
+		{"web-1", []string{"[web"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesAny(tt.name, tt.patterns); got != tt.want {
+			t.Errorf("matchesAny(%q, %q) = %v, want %v", tt.name, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestValidPatterns(t *testing.T) {
+	if err := validPatterns([]string{"web-*", "db-?", "app"}); err != nil {
+		t.Errorf("valid patterns: %s", err)
+	}
+	if err := validPatterns([]string{"web-*", "[web"}); err == nil {
+		t.Error("malformed pattern wasn't rejected")
+	}
+}
@@ -0,0 +1,398 @@
+package scanner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/guest"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guestPollInterval is how often a scan running in a guest is checked on.
+const guestPollInterval = 2 * time.Second
+
+// GuestOpsRunner runs scans inside the guest through the vSphere Guest
+// Operations API, for guests VMware Tools runs in but that can't be reached
+// over the network. The guest needs inspec installed as Bin. The profile and
+// inputs are uploaded and the reports downloaded through vCenter, logging
+// in to the guest with the target's user and password. Targets on any other
+// transport, such as the ESXi hosts, are scanned by CLI.
+type GuestOpsRunner struct {
+	CLI     CLIRunner
+	Client  *vim25.Client
+	Bin     string
+	Timeout time.Duration
+}
+
+func (r GuestOpsRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	if targetTransport(t) != TransportGuestOps {
+		return r.CLI.Run(ctx, t, profile)
+	}
+
+	res := Result{Target: t.Name, Profile: profile}
+
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	g, err := newGuestSession(ctx, r.Client, t)
+	if err != nil {
+		return res, err
+	}
+	defer g.close()
+
+	args := []string{r.Bin, "exec"}
+
+	// remote profiles are fetched by the guest itself
+	p := r.CLI.profilePath(profile)
+	if strings.Contains(p, "://") || strings.HasPrefix(p, "git@") {
+		args = append(args, p)
+	} else {
+		b, err := archiveProfile(p)
+		if err != nil {
+			return res, fmt.Errorf("can't archive profile %s: %s", p, err)
+		}
+		gp, err := g.upload(ctx, "profile.tar.gz", b)
+		if err != nil {
+			return res, err
+		}
+		args = append(args, gp)
+	}
+
+	args = append(args, r.CLI.ExtraArgs...)
+
+	for i, f := range t.InputFiles {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return res, err
+		}
+		gp, err := g.upload(ctx, fmt.Sprintf("inputs%d.yml", i), b)
+		if err != nil {
+			return res, err
+		}
+		args = append(args, "--input-file", gp)
+	}
+
+	for i, f := range t.WaiverFiles {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return res, err
+		}
+		gp, err := g.upload(ctx, fmt.Sprintf("waivers%d.yml", i), b)
+		if err != nil {
+			return res, err
+		}
+		args = append(args, "--waiver-file", gp)
+	}
+
+	// the reports are written in the guest under the names of the local
+	// files, and downloaded once inspec is done
+	var formats []string
+	for f := range t.Reporter {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+
+	args = append(args, "--reporter")
+	for _, f := range formats {
+		if file := reportFile(t, f); file != "" {
+			args = append(args, f+":"+g.path(filepath.Base(file)))
+		} else {
+			args = append(args, f)
+		}
+	}
+
+	slog.Debug("running inspec in guest", "target", t.Name, "cmd", strings.Join(args, " "))
+	code, err := g.run(ctx, args, g.path("stdout.log"), g.path("stderr.log"))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return res, fmt.Errorf("timed out after %s: %w", r.Timeout, ctx.Err())
+		}
+		return res, err
+	}
+	res.ExitCode = code
+
+	if b, err := g.download(ctx, "stdout.log"); err == nil {
+		res.Stdout = string(b)
+	}
+	if b, err := g.download(ctx, "stderr.log"); err == nil {
+		stderr := &tailBuffer{max: maxStderr}
+		stderr.Write(b)
+		res.Stderr = stderr.String()
+	}
+
+	for _, f := range formats {
+		file := reportFile(t, f)
+		if file == "" {
+			continue
+		}
+
+		b, err := g.download(ctx, filepath.Base(file))
+		if err != nil {
+			return res, fmt.Errorf("can't download %s report: %s", f, err)
+		}
+		if err := writeFileAtomic(file, b); err != nil {
+			return res, err
+		}
+	}
+
+	if !scanCompleted(code) {
+		return res, fmt.Errorf("exit status %d: %s", code, stderrTail(res.Stderr))
+	}
+
+	return res, nil
+}
+
+// guestSession is a temp directory in a guest, and what's needed to work
+// in it.
+type guestSession struct {
+	c       *vim25.Client
+	pm      *guest.ProcessManager
+	fm      *guest.FileManager
+	auth    types.BaseGuestAuthentication
+	windows bool
+	dir     string
+}
+
+// newGuestSession logs in to the guest of t's vm and creates a temp
+// directory in it.
+func newGuestSession(ctx context.Context, c *vim25.Client, t TargetConfig) (*guestSession, error) {
+	vm, err := inventory.FindVM(ctx, c, t.VM.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	om := guest.NewOperationsManager(c, vm.Reference())
+
+	g := &guestSession{
+		c:       c,
+		auth:    &types.NamePasswordAuthentication{Username: t.User, Password: t.Password},
+		windows: t.VM.GuestFamily == windowsGuestFamily,
+	}
+
+	if g.pm, err = om.ProcessManager(ctx); err != nil {
+		return nil, err
+	}
+	if g.fm, err = om.FileManager(ctx); err != nil {
+		return nil, err
+	}
+
+	if g.dir, err = g.fm.CreateTemporaryDirectory(ctx, g.auth, "vmware-poc", "", ""); err != nil {
+		return nil, fmt.Errorf("can't log in to guest: %s", err)
+	}
+
+	return g, nil
+}
+
+// path returns the path of name in the temp directory.
+func (g *guestSession) path(name string) string {
+	if g.windows {
+		return g.dir + `\` + name
+	}
+
+	return g.dir + "/" + name
+}
+
+// upload writes data to name in the temp directory, returning its path.
+func (g *guestSession) upload(ctx context.Context, name string, data []byte) (string, error) {
+	p := g.path(name)
+
+	u, err := g.fm.InitiateFileTransferToGuest(ctx, g.auth, p, &types.GuestFileAttributes{}, int64(len(data)), true)
+	if err != nil {
+		return "", fmt.Errorf("can't upload %s: %s", name, err)
+	}
+
+	tu, err := g.fm.TransferURL(ctx, u)
+	if err != nil {
+		return "", err
+	}
+
+	param := soap.DefaultUpload
+	param.ContentLength = int64(len(data))
+	if err := g.c.Upload(ctx, bytes.NewReader(data), tu, &param); err != nil {
+		return "", fmt.Errorf("can't upload %s: %s", name, err)
+	}
+
+	return p, nil
+}
+
+// download reads name from the temp directory.
+func (g *guestSession) download(ctx context.Context, name string) ([]byte, error) {
+	info, err := g.fm.InitiateFileTransferFromGuest(ctx, g.auth, g.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	tu, err := g.fm.TransferURL(ctx, info.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, _, err := g.c.Download(ctx, tu, &soap.DefaultDownload)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// run runs args in the guest with its output sent to the stdout and
+// stderr files, and returns its exit code once it's done. It's killed if
+// ctx is done first.
+func (g *guestSession) run(ctx context.Context, args []string, stdout, stderr string) (int, error) {
+	if g.windows {
+		command, err := windowsQuote(args)
+		if err != nil {
+			return 0, err
+		}
+		return g.runCommand(ctx, command, stdout, stderr)
+	}
+
+	return g.runCommand(ctx, shellJoin(args), stdout, stderr)
+}
+
+// runCommand is run for a command line, which the guest's shell parses
+// as it is, quotes, pipes and all.
+func (g *guestSession) runCommand(ctx context.Context, command, stdout, stderr string) (int, error) {
+	var spec types.GuestProgramSpec
+	if g.windows {
+		spec = types.GuestProgramSpec{
+			ProgramPath: `C:\Windows\System32\cmd.exe`,
+			Arguments:   fmt.Sprintf(`/c "%s > "%s" 2> "%s""`, command, stdout, stderr),
+		}
+	} else {
+		spec = types.GuestProgramSpec{
+			ProgramPath: "/bin/sh",
+			Arguments:   "-c " + shellQuote(fmt.Sprintf("%s > %s 2> %s", command, shellQuote(stdout), shellQuote(stderr))),
+		}
+	}
+	spec.WorkingDirectory = g.dir
+
+	pid, err := g.pm.StartProgram(ctx, g.auth, &spec)
+	if err != nil {
+		return 0, fmt.Errorf("can't start inspec in guest: %s", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the guest has to be told even though ctx is done
+			if err := g.pm.TerminateProcess(context.Background(), g.auth, pid); err != nil {
+				slog.Warn("can't kill inspec in guest", "pid", pid, "err", err)
+			}
+			return 0, ctx.Err()
+		case <-time.After(guestPollInterval):
+		}
+
+		ps, err := g.pm.ListProcesses(ctx, g.auth, []int64{pid})
+		if err != nil {
+			return 0, err
+		}
+		if len(ps) == 0 {
+			return 0, errors.New("inspec disappeared from the guest")
+		}
+		if ps[0].EndTime != nil {
+			return int(ps[0].ExitCode), nil
+		}
+	}
+}
+
+// close removes the temp directory.
+func (g *guestSession) close() {
+	if err := g.fm.DeleteDirectory(context.Background(), g.auth, g.dir, true); err != nil {
+		slog.Warn("can't clean up guest", "dir", g.dir, "err", err)
+	}
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes every one of args for a POSIX shell.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// windowsQuote quotes every one of args for cmd.exe, doubling the quotes
+// in them. cmd.exe expands % and ^ even within quotes, so args with them
+// are refused rather than let them change the command.
+func windowsQuote(args []string) (string, error) {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if strings.ContainsAny(a, "%^") {
+			return "", fmt.Errorf("can't pass %q to cmd.exe, it has %% or ^ in it", a)
+		}
+		quoted[i] = `"` + strings.ReplaceAll(a, `"`, `""`) + `"`
+	}
+
+	return strings.Join(quoted, " "), nil
+}
+
+// archiveProfile returns the profile in dir as a tar.gz, which inspec runs
+// like the directory.
+func archiveProfile(dir string) ([]byte, error) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,30 @@
+package scanner
+
+import "testing"
+
+func TestShellJoin(t *testing.T) {
+	args := []string{"inspec", "exec", "/tmp/it's here", "--input", "name=$(id)"}
+	want := `'inspec' 'exec' '/tmp/it'\''s here' '--input' 'name=$(id)'`
+	if got := shellJoin(args); got != want {
+		t.Errorf("shellJoin = %s, want %s", got, want)
+	}
+}
+
+func TestWindowsQuote(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+		ok   bool
+	}{
+		{[]string{"inspec", "exec", `C:\Temp\profile.tar.gz`}, `"inspec" "exec" "C:\Temp\profile.tar.gz"`, true},
+		{[]string{"--input", `name=a" & del C:\x & "`}, `"--input" "name=a"" & del C:\x & """`, true},
+		{[]string{"--input", "path=%TEMP%"}, "", false},
+		{[]string{"--input", "a^&b"}, "", false},
+	}
+	for _, tt := range tests {
+		got, err := windowsQuote(tt.args)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("windowsQuote(%q) = %s, %v, want %s, ok %v", tt.args, got, err, tt.want, tt.ok)
+		}
+	}
+}
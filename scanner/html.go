@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"path/filepath"
+	"time"
+)
+
+//go:embed templates/report.html
+var templates embed.FS
+
+var reportTemplate = template.Must(template.ParseFS(templates, "templates/report.html"))
+
+// htmlTopFailing is how many of the most failed controls the HTML report
+// lists.
+const htmlTopFailing = 10
+
+// htmlReport is what the --html-report template renders.
+type htmlReport struct {
+	VCenter    string
+	Version    string
+	Generated  time.Time
+	Totals     JSONTotals
+	TopFailing []ControlCount
+	Targets    []htmlTarget
+}
+
+// htmlTarget is a row of the HTML report. Report links to the target's
+// json report, relative to the HTML file.
+type htmlTarget struct {
+	JSONTargetSummary
+	Score  float64
+	Report string
+}
+
+// writeHTMLReport renders the summary js of targets as a single page at
+// path.
+func writeHTMLReport(path string, js JSONSummary, targets []TargetConfig, summaries []Summary) error {
+	r := htmlReport{
+		VCenter:    js.ConnectedTo,
+		Version:    js.VCenterVersion,
+		Generated:  time.Now(),
+		Totals:     js.Totals,
+		TopFailing: topFailingControls(targets, htmlTopFailing),
+	}
+
+	scores := map[string]float64{}
+	for _, s := range summaries {
+		scores[s.Target] = s.ComplianceScore
+	}
+
+	// js has a summary of every target, in the same order
+	for i, t := range targets {
+		ht := htmlTarget{JSONTargetSummary: js.Targets[i], Score: scores[t.Name]}
+
+		if f := reportFile(t, "json"); t.Skip == "" && f != "" {
+			ht.Report = f
+			if rel, err := filepath.Rel(filepath.Dir(path), f); err == nil {
+				ht.Report = filepath.ToSlash(rel)
+			}
+		}
+
+		r.Targets = append(r.Targets, ht)
+	}
+
+	var b bytes.Buffer
+	if err := reportTemplate.Execute(&b, r); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b.Bytes())
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// inputVars are what input templates can refer to, e.g.
+//
+//	admin: "{{.Name}}-admin"
+//	allowed_hosts: ["{{.IP}}"]
+type inputVars struct {
+	Name        string
+	Host        string
+	IP          string
+	GuestFamily string
+	GuestID     string
+}
+
+// loadInputs reads an --input-file of InSpec profile inputs.
+func loadInputs(path string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &inputs); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return inputs, nil
+}
+
+// renderInputs returns inputs with every string in them, however deeply
+// nested, expanded as a template of vars.
+func renderInputs(inputs map[string]interface{}, vars inputVars) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for k, v := range inputs {
+		r, err := renderInput(v, vars)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %s", k, err)
+		}
+		out[k] = r
+	}
+
+	return out, nil
+}
+
+func renderInput(v interface{}, vars inputVars) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		t, err := template.New("input").Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+
+		var b bytes.Buffer
+		if err := t.Execute(&b, vars); err != nil {
+			return nil, err
+		}
+
+		return b.String(), nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			r, err := renderInput(e, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+
+		return out, nil
+	case map[interface{}]interface{}:
+		out := map[interface{}]interface{}{}
+		for k, e := range v {
+			r, err := renderInput(e, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+
+		return out, nil
+	}
+
+	return v, nil
+}
+
+// writeInputs renders inputs for t and writes them to path, to be passed to
+// inspec as an input file.
+func writeInputs(path string, inputs map[string]interface{}, t TargetConfig) error {
+	rendered, err := renderInputs(inputs, inputVars{
+		Name:        t.VM.Name,
+		Host:        t.VM.Host,
+		IP:          t.VM.IP,
+		GuestFamily: t.VM.GuestFamily,
+		GuestID:     t.VM.GuestID,
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := yaml.Marshal(rendered)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b)
+}
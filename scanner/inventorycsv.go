@@ -0,0 +1,34 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/csv"
+)
+
+// inventoryHeader are the columns of an --inventory-csv file.
+var inventoryHeader = []string{"name", "uuid", "guest_family", "power_state", "ip", "host", "datacenter", "annotation"}
+
+// writeInventoryCSV writes the vms of targets to path, one row each.
+func writeInventoryCSV(path string, targets []TargetConfig) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+
+	if err := w.Write(inventoryHeader); err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		vm := t.VM
+		row := []string{vm.Name, vm.UUID, vm.GuestFamily, string(vm.PowerState), vm.IP, vm.Host, vm.Datacenter, vm.Annotation}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b.Bytes())
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// junitTestSuites is the root of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the controls of a single target.
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a single control.
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// newJUnitReport converts the json reports of targets into JUnit, with a
+// suite per target and a test case per control. Targets that were skipped
+// or have no readable report get a single skipped or errored test case.
+func newJUnitReport(targets []TargetConfig) junitTestSuites {
+	var r junitTestSuites
+
+	for _, t := range targets {
+		suite := junitTestSuite{Name: t.Name}
+
+		var controls []ControlResult
+		var err error
+		if t.Skip == "" {
+			controls, _, err = readControls(reportFile(t, "json"))
+		}
+
+		switch {
+		case t.Skip != "":
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      "scan",
+				Classname: t.Name,
+				Skipped:   &junitMessage{Message: t.Skip},
+			})
+			suite.Skipped++
+		case err != nil:
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      "scan",
+				Classname: t.Name,
+				Error:     &junitMessage{Message: "no report", Text: err.Error()},
+			})
+			suite.Errors++
+		default:
+			for _, c := range controls {
+				tc := junitTestCase{Name: c.ID, Classname: t.Name}
+				if c.Title != "" {
+					tc.Name = c.ID + ": " + c.Title
+				}
+
+				switch c.Status {
+				case statusFailed:
+					tc.Failure = &junitMessage{Message: c.Title, Text: strings.Join(c.Messages, "\n\n")}
+					suite.Failures++
+				case statusSkipped:
+					tc.Skipped = &junitMessage{}
+					suite.Skipped++
+				case statusWaived:
+					tc.Skipped = &junitMessage{Message: statusWaived}
+					suite.Skipped++
+				}
+
+				suite.Cases = append(suite.Cases, tc)
+			}
+		}
+
+		suite.Tests = len(suite.Cases)
+
+		r.Tests += suite.Tests
+		r.Failures += suite.Failures
+		r.Errors += suite.Errors
+		r.Skipped += suite.Skipped
+		r.Suites = append(r.Suites, suite)
+	}
+
+	return r
+}
+
+// writeJUnitReport writes the JUnit report of targets to path.
+func writeJUnitReport(path string, targets []TargetConfig) error {
+	b, err := xml.MarshalIndent(newJUnitReport(targets), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append([]byte(xml.Header), b...))
+}
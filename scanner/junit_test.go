@@ -0,0 +1,82 @@
+package scanner
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestJUnitReport(t *testing.T) {
+	targets := []TargetConfig{
+		{Name: "web-1", Reporter: buildReporter([]string{"json"}, "../result/testdata/linux-baseline")},
+		{Name: "web-2", Reporter: buildReporter([]string{"json"}, "../result/testdata/missing")},
+		{Name: "web-3", Skip: skipNotPoweredOn},
+	}
+
+	b, err := xml.Marshal(newJUnitReport(targets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the xml back the way a JUnit consumer would
+	var got struct {
+		XMLName  xml.Name `xml:"testsuites"`
+		Tests    int      `xml:"tests,attr"`
+		Failures int      `xml:"failures,attr"`
+		Errors   int      `xml:"errors,attr"`
+		Skipped  int      `xml:"skipped,attr"`
+		Suites   []struct {
+			Name  string `xml:"name,attr"`
+			Tests int    `xml:"tests,attr"`
+			Cases []struct {
+				Name      string `xml:"name,attr"`
+				Classname string `xml:"classname,attr"`
+				Failure   *struct {
+					Message string `xml:"message,attr"`
+					Text    string `xml:",chardata"`
+				} `xml:"failure"`
+				Error   *struct{} `xml:"error"`
+				Skipped *struct{} `xml:"skipped"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(b, &got); err != nil {
+		t.Fatalf("%s: %s", b, err)
+	}
+
+	if got.Tests != 5 || got.Failures != 1 || got.Errors != 1 || got.Skipped != 2 {
+		t.Errorf("totals = %d tests, %d failures, %d errors, %d skipped; want 5, 1, 1, 2", got.Tests, got.Failures, got.Errors, got.Skipped)
+	}
+
+	if len(got.Suites) != 3 {
+		t.Fatalf("%d suites, want one per target", len(got.Suites))
+	}
+
+	web1 := got.Suites[0]
+	if web1.Name != "web-1" || web1.Tests != 3 || len(web1.Cases) != 3 {
+		t.Fatalf("suite %s has %d tests, want web-1 with 3", web1.Name, web1.Tests)
+	}
+
+	failed := web1.Cases[1]
+	if failed.Name != "os-02: Check owner and permissions for /etc/shadow" || failed.Classname != "web-1" {
+		t.Errorf("test case = %s (%s), want os-02 of web-1", failed.Name, failed.Classname)
+	}
+	if failed.Failure == nil {
+		t.Fatal("os-02 has no failure")
+	}
+	if want := "File /etc/shadow is expected to be owned by \"root\"\nexpected `File /etc/shadow.owned_by?(\"root\")` to be truthy, got false"; failed.Failure.Text != want {
+		t.Errorf("failure text = %q, want %q", failed.Failure.Text, want)
+	}
+	if web1.Cases[0].Failure != nil || web1.Cases[0].Skipped != nil {
+		t.Error("passed control os-01 failed or was skipped")
+	}
+	if web1.Cases[2].Skipped == nil {
+		t.Error("skipped control os-10 wasn't skipped")
+	}
+
+	if c := got.Suites[1].Cases; len(c) != 1 || c[0].Error == nil {
+		t.Error("target without a report didn't error")
+	}
+	if c := got.Suites[2].Cases; len(c) != 1 || c[0].Skipped == nil {
+		t.Error("skipped target wasn't skipped")
+	}
+}
@@ -0,0 +1,90 @@
+package scanner
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scanMetrics are the Prometheus metrics of a run.
+type scanMetrics struct {
+	duration *prometheus.HistogramVec
+	controls *prometheus.CounterVec
+	targets  prometheus.Counter
+}
+
+func newScanMetrics(reg *prometheus.Registry) *scanMetrics {
+	m := &scanMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "vmware_poc_scan_duration_seconds",
+			Help:    "Time taken by InSpec to scan a target.",
+			Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+		}, []string{"target"}),
+		controls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "vmware_poc_controls_total",
+			Help: "Controls run, by status.",
+		}, []string{"status"}),
+		targets: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vmware_poc_targets_total",
+			Help: "Targets scanned.",
+		}),
+	}
+
+	reg.MustRegister(m.duration, m.controls, m.targets)
+
+	return m
+}
+
+// serveMetrics serves a new set of metrics on addr until the returned
+// server is shut down.
+func serveMetrics(addr string) (*scanMetrics, *http.Server, error) {
+	// listen first so a bad address fails the run rather than a goroutine
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reg := prometheus.NewRegistry()
+	m := newScanMetrics(reg)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			slog.Error("metrics server failed", "addr", addr, "err", err)
+		}
+	}()
+
+	return m, srv, nil
+}
+
+// metricsRunner records the duration and controls of every scan as it
+// completes.
+type metricsRunner struct {
+	Runner
+	m *scanMetrics
+}
+
+func (r metricsRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	start := time.Now()
+	res, err := r.Runner.Run(ctx, t, profile)
+
+	r.m.duration.WithLabelValues(t.Name).Observe(time.Since(start).Seconds())
+	r.m.targets.Inc()
+
+	if s, serr := readSummary(t.Name, reportFile(t, "json")); serr == nil {
+		r.m.controls.WithLabelValues(statusPassed).Add(float64(s.Passed))
+		r.m.controls.WithLabelValues(statusFailed).Add(float64(s.Failed))
+		r.m.controls.WithLabelValues(statusSkipped).Add(float64(s.Skipped))
+		r.m.controls.WithLabelValues(statusWaived).Add(float64(s.Waived))
+	}
+
+	return res, err
+}
@@ -0,0 +1,68 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// pickTargetIP returns the first address of the guest NICs nets that is in
+// cidr, or without a cidr the first that isn't loopback or link-local.
+// IPv4 addresses are picked over IPv6 ones unless preferIPv6 is set, but
+// either is better than none. It fails if no address qualifies.
+func pickTargetIP(nets []types.GuestNicInfo, cidr string, preferIPv6 bool) (string, error) {
+	var network *net.IPNet
+	if cidr != "" {
+		var err error
+		if _, network, err = net.ParseCIDR(cidr); err != nil {
+			return "", err
+		}
+	}
+
+	var other string
+	for _, nic := range nets {
+		for _, a := range nicAddresses(nic) {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				continue
+			}
+
+			if network != nil && !network.Contains(ip) || network == nil && !ip.IsGlobalUnicast() {
+				continue
+			}
+
+			if v6 := ip.To4() == nil; v6 == preferIPv6 {
+				return ip.String(), nil
+			}
+			if other == "" {
+				other = ip.String()
+			}
+		}
+	}
+
+	if other != "" {
+		return other, nil
+	}
+
+	if network != nil {
+		return "", fmt.Errorf("no guest IP in %s", cidr)
+	}
+
+	return "", fmt.Errorf("no routable guest IP")
+}
+
+// nicAddresses returns the addresses of nic, from its IP config if VMware
+// Tools reported one.
+func nicAddresses(nic types.GuestNicInfo) []string {
+	if nic.IpConfig == nil {
+		return nic.IpAddress
+	}
+
+	var addrs []string
+	for _, a := range nic.IpConfig.IpAddress {
+		addrs = append(addrs, a.IpAddress)
+	}
+
+	return addrs
+}
@@ -0,0 +1,83 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestTargetURI(t *testing.T) {
+	tests := []struct {
+		transport, host string
+		want            string
+	}{
+		{transportSSH, "10.0.0.5", "ssh://10.0.0.5"},
+		{transportSSH, "2001:db8::5", "ssh://[2001:db8::5]"},
+		{transportWinRM, "fe80::1", "winrm://[fe80::1]"},
+		{transportVMware, "esx1.example.com", "vmware://esx1.example.com"},
+	}
+
+	for _, tt := range tests {
+		got := targetURI(tt.transport, tt.host)
+		if got != tt.want {
+			t.Errorf("targetURI(%q, %q) = %q, want %q", tt.transport, tt.host, got, tt.want)
+		}
+		if h := targetHost(TargetConfig{Target: got}); h != tt.host {
+			t.Errorf("targetHost(%q) = %q, want %q", got, h, tt.host)
+		}
+	}
+}
+
+func TestNewTargetIPv6(t *testing.T) {
+	vm := inventory.VMInfo{Name: "web-1", IP: "2001:db8::5", GuestFamily: "linuxGuest"}
+
+	got := newTarget(discoverOptions{}, vm, "linux-baseline", Credential{User: "root", Password: "secret"})
+	if want := "ssh://[2001:db8::5]"; got.Target != want {
+		t.Errorf("Target = %q, want %q", got.Target, want)
+	}
+}
+
+func TestPickTargetIP(t *testing.T) {
+	// a vm with a link-local, an isolated and a routable NIC, each dual
+	// stack
+	nets := []types.GuestNicInfo{
+		{Network: "link", IpAddress: []string{"169.254.10.1", "fe80::1"}},
+		{Network: "backup", IpAddress: []string{"192.168.50.4", "fd00:50::4"}},
+		{Network: "prod", IpConfig: &types.NetIpConfigInfo{IpAddress: []types.NetIpConfigInfoIpAddress{
+			{IpAddress: "10.20.0.7", PrefixLength: 24},
+			{IpAddress: "2001:db8:20::7", PrefixLength: 64},
+		}}},
+	}
+	v6Only := []types.GuestNicInfo{{IpAddress: []string{"fe80::2", "2001:db8:30::9"}}}
+
+	tests := []struct {
+		name       string
+		nets       []types.GuestNicInfo
+		cidr       string
+		preferIPv6 bool
+		want       string
+		wantErr    bool
+	}{
+		{name: "first routable", nets: nets, want: "192.168.50.4"},
+		{name: "first routable ipv6", nets: nets, preferIPv6: true, want: "fd00:50::4"},
+		{name: "target network", nets: nets, cidr: "10.20.0.0/16", want: "10.20.0.7"},
+		{name: "ipv6 target network", nets: nets, cidr: "2001:db8:20::/48", want: "2001:db8:20::7"},
+		{name: "ipv6 only", nets: v6Only, want: "2001:db8:30::9"},
+		{name: "not in target network", nets: nets, cidr: "172.16.0.0/12", wantErr: true},
+		{name: "link-local only", nets: []types.GuestNicInfo{{IpAddress: []string{"169.254.1.1", "fe80::3"}}}, wantErr: true},
+		{name: "no NICs", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickTargetIP(tt.nets, tt.cidr, tt.preferIPv6)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("pickTargetIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
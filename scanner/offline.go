@@ -0,0 +1,161 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/vmware/govmomi/vim25"
+	"gopkg.in/yaml.v2"
+)
+
+// Scan modes
+const (
+	ModeLive    = "live"
+	ModeOffline = "offline"
+)
+
+var ScanModes = []string{ModeLive, ModeOffline}
+
+// offlineTarget is what inspec is pointed at in offline mode: the scanner
+// itself, so nothing goes over the network.
+const offlineTarget = "local://"
+
+// skipHostFacts is why ESXi hosts aren't scanned when facts are gathered in
+// the guests.
+const skipHostFacts = "skipped - no guest to gather facts in"
+
+// errFacts marks offline scans that didn't run because the target's facts
+// couldn't be had.
+var errFacts = errors.New("no facts")
+
+// FactProvider gets the facts of a target, such as its packages or
+// settings, for an offline scan. Facts are named like the profile inputs
+// they are passed as.
+type FactProvider interface {
+	Facts(ctx context.Context, t TargetConfig) (map[string]interface{}, error)
+}
+
+// dirFactProvider reads facts collected earlier from <outputName>.yml, or
+// .json, in its directory.
+type dirFactProvider string
+
+func (d dirFactProvider) Facts(ctx context.Context, t TargetConfig) (map[string]interface{}, error) {
+	var b []byte
+	var err error
+	for _, ext := range []string{".yml", ".yaml", ".json"} {
+		if b, err = os.ReadFile(filepath.Join(string(d), outputName(t)+ext)); !os.IsNotExist(err) {
+			break
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no %s.yml or .json in %s", outputName(t), string(d))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var facts map[string]interface{}
+	if err := yaml.Unmarshal(b, &facts); err != nil {
+		return nil, fmt.Errorf("%s: %s", outputName(t), err)
+	}
+
+	return facts, nil
+}
+
+// guestFactProvider gathers facts by running command with the guest's shell
+// through the Guest Operations API, like --transport=guestops does inspec.
+// The command prints the facts as YAML, or JSON, to stdout. ESXi hosts have
+// no guest, and are skipped with skipHostFacts.
+type guestFactProvider struct {
+	c       *vim25.Client
+	command string
+}
+
+func (p guestFactProvider) Facts(ctx context.Context, t TargetConfig) (map[string]interface{}, error) {
+	if t.VM.UUID == "" {
+		return nil, errors.New("facts can only be gathered from vms")
+	}
+
+	g, err := newGuestSession(ctx, p.c, t)
+	if err != nil {
+		return nil, err
+	}
+	defer g.close()
+
+	code, err := g.runCommand(ctx, p.command, g.path("facts.yml"), g.path("facts.err"))
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		stderr, _ := g.download(ctx, "facts.err")
+		return nil, fmt.Errorf("fact command exited %d: %s", code, stderrTail(string(stderr)))
+	}
+
+	b, err := g.download(ctx, "facts.yml")
+	if err != nil {
+		return nil, err
+	}
+
+	var facts map[string]interface{}
+	if err := yaml.Unmarshal(b, &facts); err != nil {
+		return nil, fmt.Errorf("fact command output: %s", err)
+	}
+
+	return facts, nil
+}
+
+// offlineRunner scans targets without connecting to them: their facts are
+// passed as inputs to a profile that inspec runs on the scanner. Profiles
+// for offline mode have to check those inputs rather than resources of
+// the target.
+type offlineRunner struct {
+	CLI   CLIRunner
+	facts FactProvider
+}
+
+func (r offlineRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	res := Result{Target: t.Name, Profile: profile}
+
+	facts, err := r.facts.Facts(ctx, t)
+	if err != nil {
+		return res, fmt.Errorf("%w: %s", errFacts, err)
+	}
+
+	b, err := yaml.Marshal(facts)
+	if err != nil {
+		return res, err
+	}
+
+	f, err := os.CreateTemp("", "vmware-poc-facts-*.yml")
+	if err != nil {
+		return res, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, err
+	}
+
+	// none of the target's logins are needed on the scanner
+	local := TargetConfig{
+		Name:        t.Name,
+		Target:      offlineTarget,
+		Reporter:    t.Reporter,
+		LogLevel:    t.LogLevel,
+		InputFiles:  append(slices.Clone(t.InputFiles), f.Name()),
+		WaiverFiles: t.WaiverFiles,
+		Profile:     t.Profile,
+		Profiles:    t.Profiles,
+		VM:          t.VM,
+	}
+
+	return r.CLI.Run(ctx, local, profile)
+}
@@ -0,0 +1,79 @@
+package scanner
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// Plan is the --plan output: every scan a run would make, for review
+// before it does. Field names are part of the output format, so don't
+// rename them.
+type Plan struct {
+	VCenter string       `json:"vcenter"`
+	Targets []PlanTarget `json:"targets"`
+}
+
+// PlanTarget is the scan of a single target in a Plan. It never holds a
+// secret, only where the credential comes from.
+type PlanTarget struct {
+	Name             string         `json:"name"`
+	IP               string         `json:"ip,omitempty"`
+	Profile          string         `json:"profile,omitempty"`
+	Transport        string         `json:"transport,omitempty"`
+	User             string         `json:"user,omitempty"`
+	CredentialSource string         `json:"credential_source,omitempty"`
+	Reporters        []PlanReporter `json:"reporters,omitempty"`
+	Command          []string       `json:"command,omitempty"`
+	Skip             string         `json:"skip,omitempty"`
+}
+
+// PlanReporter is an InSpec reporter of a PlanTarget and the file it
+// writes, if any.
+type PlanReporter struct {
+	Format string `json:"format"`
+	File   string `json:"file,omitempty"`
+}
+
+// newPlan builds the --plan output for targets as r would scan them.
+func newPlan(vcenter string, r CLIRunner, targets []TargetConfig) Plan {
+	p := Plan{VCenter: vcenter, Targets: []PlanTarget{}}
+
+	for _, t := range targets {
+		if t.Skip != "" {
+			p.Targets = append(p.Targets, PlanTarget{Name: t.Name, IP: targetHost(t), Skip: t.Skip})
+			continue
+		}
+
+		pt := PlanTarget{
+			Name:             t.Name,
+			IP:               targetHost(t),
+			Profile:          t.Profile,
+			Transport:        targetTransport(t),
+			User:             t.User,
+			CredentialSource: t.CredentialSource,
+			Command:          append([]string{r.Bin}, r.Args(t.Profile)...),
+		}
+
+		var formats []string
+		for f := range t.Reporter {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+		for _, f := range formats {
+			pt.Reporters = append(pt.Reporters, PlanReporter{Format: f, File: reportFile(t, f)})
+		}
+
+		p.Targets = append(p.Targets, pt)
+	}
+
+	return p
+}
+
+// printPlanJSON writes p as indented JSON to stdout.
+func printPlanJSON(p Plan) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(p)
+}
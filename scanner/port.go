@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"slices"
+)
+
+// PortRule is the port inspec connects to the guests in CIDR on, over
+// Transport or, if it has none, over ssh and winrm alike.
+type PortRule struct {
+	CIDR      string `yaml:"cidr"`
+	Transport string `yaml:"transport"`
+	Port      int    `yaml:"port"`
+
+	network *net.IPNet
+}
+
+// compilePorts checks rules and parses their CIDRs.
+func compilePorts(rules []PortRule) ([]PortRule, error) {
+	out := make([]PortRule, len(rules))
+	for i, r := range rules {
+		if r.Port < 1 || r.Port > 65535 {
+			return nil, fmt.Errorf("port rule %d: bad port %d", i+1, r.Port)
+		}
+		if r.Transport != "" && !slices.Contains([]string{transportSSH, transportWinRM}, r.Transport) {
+			return nil, fmt.Errorf("port rule %d: bad transport %q", i+1, r.Transport)
+		}
+
+		var err error
+		if _, r.network, err = net.ParseCIDR(r.CIDR); err != nil {
+			return nil, fmt.Errorf("port rule %d: %s", i+1, err)
+		}
+
+		out[i] = r
+	}
+
+	return out, nil
+}
+
+// portFor returns the port of the first of rules for transport whose
+// subnet holds ip, else def; 0 is the transport's default port.
+func portFor(rules []PortRule, transport, ip string, def int) int {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return def
+	}
+
+	for _, r := range rules {
+		if (r.Transport == "" || r.Transport == transport) && r.network.Contains(addr) {
+			return r.Port
+		}
+	}
+
+	return def
+}
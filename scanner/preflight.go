@@ -0,0 +1,57 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// preflight checks that the session can read what discovery needs, so a
+// missing privilege fails the run up front with a hint instead of with a
+// SOAP fault halfway through discovery.
+func preflight(ctx context.Context, c *govmomi.Client) error {
+	us, err := c.SessionManager.UserSession(ctx)
+	if err != nil {
+		return fmt.Errorf("can't read the vCenter session: %s", err)
+	}
+	if us == nil {
+		return errors.New("not logged in to vCenter; check the user and password")
+	}
+
+	hint := fmt.Sprintf("give %s at least the Read-only role on the vCenter root, propagated to children", us.UserName)
+
+	dcs, err := find.NewFinder(c.Client, true).DatacenterList(ctx, "*")
+	if err != nil || len(dcs) == 0 {
+		return fmt.Errorf("%s can't see any datacenter (%v); %s", us.UserName, err, hint)
+	}
+
+	m := view.NewManager(c.Client)
+	for _, kind := range []string{"HostSystem", "VirtualMachine"} {
+		v, err := m.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{kind}, true)
+		if err != nil {
+			return fmt.Errorf("%s can't create a %s view: %s; %s", us.UserName, kind, err, hint)
+		}
+
+		switch kind {
+		case "HostSystem":
+			var hosts []mo.HostSystem
+			err = v.Retrieve(ctx, []string{kind}, []string{"name"}, &hosts)
+		case "VirtualMachine":
+			var vms []mo.VirtualMachine
+			err = v.Retrieve(ctx, []string{kind}, []string{"name"}, &vms)
+		}
+
+		v.Destroy(context.Background())
+
+		if err != nil {
+			return fmt.Errorf("%s can't read %s objects: %s; %s", us.UserName, kind, err, hint)
+		}
+	}
+
+	return nil
+}
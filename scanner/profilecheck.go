@@ -0,0 +1,114 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// profileCheckTimeout bounds each --validate-profiles inspec check, which
+// may have to fetch a remote profile.
+const profileCheckTimeout = 2 * time.Minute
+
+// profileCheck is the outcome of checking a profile.
+type profileCheck struct {
+	Profile string
+	Path    string
+	Err     error
+}
+
+// distinctProfiles returns every profile a run could scan with: the
+// vSphere profile of the hosts, then list and the profiles of profileMap,
+// each once.
+func distinctProfiles(list []string, profileMap map[string]string) []string {
+	out := []string{vsphereProfile}
+	add := func(p string) {
+		for _, q := range out {
+			if q == p {
+				return
+			}
+		}
+		out = append(out, p)
+	}
+
+	for _, p := range list {
+		add(p)
+	}
+	var keys []string
+	for k := range profileMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, p := range strings.Split(profileMap[k], profileSep) {
+			add(p)
+		}
+	}
+
+	return out
+}
+
+// checkProfiles runs inspec check on each of profiles, as r resolves them,
+// and returns how they fared in the same order. Local profiles that don't
+// exist aren't handed to inspec.
+func checkProfiles(ctx context.Context, r CLIRunner, profiles []string) []profileCheck {
+	results := make([]profileCheck, len(profiles))
+	for i, p := range profiles {
+		path := r.profilePath(p)
+		results[i] = profileCheck{Profile: p, Path: path, Err: checkProfile(ctx, r.Bin, path)}
+	}
+
+	return results
+}
+
+// isRemoteProfile reports whether profile is fetched by inspec rather than
+// read from disk.
+func isRemoteProfile(profile string) bool {
+	return strings.Contains(profile, "://") || strings.HasPrefix(profile, "git@")
+}
+
+// checkProfile runs inspec check on the profile at path, first making sure
+// it exists if it's local.
+func checkProfile(ctx context.Context, bin, path string) error {
+	if !isRemoteProfile(path) {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, profileCheckTimeout)
+	defer cancel()
+
+	out, err := newInspecCmd(ctx, bin, []string{"check", path, "--no-color"}).CombinedOutput()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", profileCheckTimeout)
+		}
+		return fmt.Errorf("%s: %s", err, stderrTail(string(out)))
+	}
+
+	return nil
+}
+
+// printProfileChecks prints the outcome of checkProfiles as a table.
+func printProfileChecks(results []profileCheck) {
+	fmt.Fprintf(humanOut, "\nProfiles\n\n")
+	w := new(tabwriter.Writer)
+	w.Init(humanOut, 0, 8, 1, '\t', 0)
+
+	fmt.Fprintf(w, "PROFILE\tPATH\tSTATUS\n")
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = "broken: " + r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Profile, r.Path, status)
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// progressOut is where scan progress is printed.
+var progressOut io.Writer = os.Stderr
+
+// progress counts finished scans. It is safe for concurrent use by the
+// scan workers.
+type progress struct {
+	mu     sync.Mutex
+	w      io.Writer
+	tty    bool
+	total  int
+	done   int
+	failed int
+}
+
+// newProgress returns a progress of total scans printed to w. On a
+// terminal a single line is updated in place; otherwise every update is
+// printed on a line of its own.
+func newProgress(total int, w io.Writer) *progress {
+	return &progress{w: w, tty: isTerminal(w), total: total}
+}
+
+// Done records a finished scan and prints the progress.
+func (p *progress) Done(failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	if failed {
+		p.failed++
+	}
+
+	line := fmt.Sprintf("completed %d/%d targets (%d failed)", p.done, p.total, p.failed)
+	if p.tty {
+		fmt.Fprintf(p.w, "\r\033[K%s", line)
+	} else {
+		fmt.Fprintln(p.w, line)
+	}
+}
+
+// Finish ends the updating line on a terminal.
+func (p *progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tty && p.done > 0 {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
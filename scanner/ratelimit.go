@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"golang.org/x/time/rate"
+)
+
+// apiLimiter limits the vSphere API calls of the whole run, to every
+// vCenter and host, to --api-qps. It is unlimited until run sets it up.
+var apiLimiter = rate.NewLimiter(rate.Inf, 0)
+
+// newAPILimiter returns a limiter allowing qps calls a second, or any
+// number if qps isn't positive.
+func newAPILimiter(qps float64) *rate.Limiter {
+	if qps <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	// allow no bursts beyond a second's worth of calls
+	burst := int(qps)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+// limitedRoundTripper waits for apiLimiter before every SOAP call, which
+// covers the property collector and finder.
+type limitedRoundTripper struct {
+	soap.RoundTripper
+}
+
+func (rt limitedRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	if err := apiLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	return rt.RoundTripper.RoundTrip(ctx, req, res)
+}
+
+// limitAPI makes the SOAP calls of c wait for apiLimiter.
+func limitAPI(c *vim25.Client) {
+	c.RoundTripper = limitedRoundTripper{RoundTripper: c.RoundTripper}
+}
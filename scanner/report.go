@@ -0,0 +1,423 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gpeers/vmware-poc/result"
+)
+
+// Control statuses
+const (
+	statusPassed  = "passed"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+	statusWaived  = "waived"
+)
+
+// --fail-on policies
+const (
+	FailOnFailed  = "failed"
+	FailOnSkipped = "skipped"
+	FailOnNone    = "none"
+)
+
+var FailOnPolicies = []string{FailOnFailed, FailOnSkipped, FailOnNone}
+
+// Summary is the compliance of a single target.
+type Summary struct {
+	Target          string
+	Passed          int
+	Failed          int
+	Skipped         int
+	Waived          int
+	ComplianceScore float64
+	Note            string
+	DurationMs      int64
+	Annotation      string
+}
+
+// JSONSummary is the --summary-json output. Field names are part of the
+// output format, so don't rename them.
+type JSONSummary struct {
+	ConnectedTo    string              `json:"connected_to"`
+	VCenterVersion string              `json:"vcenter_version"`
+	Targets        []JSONTargetSummary `json:"targets"`
+	Totals         JSONTotals          `json:"totals"`
+}
+
+// JSONTargetSummary is the compliance of a single target in a JSONSummary.
+type JSONTargetSummary struct {
+	Name    string `json:"name"`
+	IP      string `json:"ip"`
+	Profile string `json:"profile"`
+	Passed  int    `json:"passed"`
+	Failed  int    `json:"failed"`
+	Skipped int    `json:"skipped"`
+	Waived  int    `json:"waived"`
+	Note    string `json:"note,omitempty"`
+
+	DurationMs int64  `json:"duration_ms"`
+	Annotation string `json:"annotation,omitempty"`
+}
+
+// JSONTotals adds up the targets of a JSONSummary. Errored counts the
+// targets without a readable report, and SkippedNoTools the ones not
+// scanned for lack of VMware Tools.
+type JSONTotals struct {
+	Targets        int `json:"targets"`
+	Passed         int `json:"passed"`
+	Failed         int `json:"failed"`
+	Skipped        int `json:"skipped"`
+	Waived         int `json:"waived"`
+	Errored        int `json:"errored"`
+	SkippedNoTools int `json:"skipped_no_tools"`
+}
+
+// TargetReport is the result of scanning a single target.
+type TargetReport struct {
+	Target  string          `json:"target"`
+	File    string          `json:"file"`
+	Passed  int             `json:"passed"`
+	Failed  int             `json:"failed"`
+	Skipped int             `json:"skipped"`
+	Waived  int             `json:"waived"`
+	Error   string          `json:"error,omitempty"`
+	Skip    string          `json:"skip,omitempty"`
+	Report  json.RawMessage `json:"report,omitempty"`
+
+	DurationMs int64 `json:"duration_ms,omitempty"`
+}
+
+// CombinedReport merges the reports of every target in a run.
+type CombinedReport struct {
+	Targets []TargetReport `json:"targets"`
+	Passed  int            `json:"passed"`
+	Failed  int            `json:"failed"`
+	Skipped int            `json:"skipped"`
+	Waived  int            `json:"waived"`
+	Errored int            `json:"errored"`
+}
+
+// ControlResult is the rolled up outcome of a single control.
+type ControlResult struct {
+	ID     string
+	Title  string
+	Impact float64
+	Status string
+
+	// Messages explain the control's failed results
+	Messages []string
+}
+
+// readControls reads the controls of a single InSpec json report, along
+// with the raw report.
+func readControls(path string) ([]ControlResult, []byte, error) {
+	b, err := readReportFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report, err := result.ParseResult(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var controls []ControlResult
+	for _, p := range report.Profiles {
+		for _, c := range p.Controls {
+			cr := ControlResult{ID: c.ID, Title: c.Title, Impact: c.Impact, Status: c.Status()}
+
+			for _, res := range c.Results {
+				if res.Status == result.StatusFailed {
+					cr.Messages = append(cr.Messages, strings.TrimSpace(res.CodeDesc+"\n"+res.Message))
+				}
+			}
+
+			controls = append(controls, cr)
+		}
+	}
+
+	return controls, b, nil
+}
+
+// reportComplete reports whether t's json report, which its results are
+// read from, was written in full by an earlier scan. A scan that was killed
+// leaves it missing or truncated, which fails to parse.
+func reportComplete(t TargetConfig) bool {
+	_, _, err := readControls(reportFile(t, "json"))
+
+	return err == nil
+}
+
+// readReport reads a single InSpec json report.
+func readReport(path string) (TargetReport, error) {
+	r := TargetReport{Target: path, File: path}
+
+	controls, b, err := readControls(path)
+	if err != nil {
+		return r, err
+	}
+
+	for _, c := range controls {
+		switch c.Status {
+		case statusPassed:
+			r.Passed++
+		case statusFailed:
+			r.Failed++
+		case statusWaived:
+			r.Waived++
+		default:
+			r.Skipped++
+		}
+	}
+
+	r.Report = b
+
+	return r, nil
+}
+
+// mergeReports reads the InSpec json reports at paths into a single report.
+// Files that are missing or can't be parsed are recorded as errored targets
+// rather than failing the merge. Targets are named after their file; the
+// caller can rename them.
+func mergeReports(paths []string) (CombinedReport, error) {
+	var c CombinedReport
+
+	for _, path := range paths {
+		r, err := readReport(path)
+		if err != nil {
+			r.Error = err.Error()
+			c.Errored++
+		}
+
+		c.Passed += r.Passed
+		c.Failed += r.Failed
+		c.Skipped += r.Skipped
+		c.Waived += r.Waived
+		c.Targets = append(c.Targets, r)
+	}
+
+	return c, nil
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it
+// into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// writeCombinedReport writes c as JSON to path, gzipped if path ends in
+// .gz.
+func writeCombinedReport(path string, c CombinedReport) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(path, gzipExt) {
+		if b, err = gzipBytes(b); err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(path, b)
+}
+
+// readSummary summarizes a single json report. Controls are counted by
+// their result.Control.Status, as in every other report.
+func readSummary(target, path string) (Summary, error) {
+	s := Summary{Target: target}
+
+	controls, _, err := readControls(path)
+	if err != nil {
+		return s, err
+	}
+
+	for _, c := range controls {
+		switch c.Status {
+		case statusPassed:
+			s.Passed++
+		case statusFailed:
+			s.Failed++
+		case statusWaived:
+			s.Waived++
+		default:
+			s.Skipped++
+		}
+	}
+
+	// the score is the share of controls that ran and passed; waived
+	// controls don't count either way
+	if s.Passed+s.Failed > 0 {
+		s.ComplianceScore = 100 * float64(s.Passed) / float64(s.Passed+s.Failed)
+	}
+
+	return s, nil
+}
+
+// summarize returns the compliance summary of every target. Targets
+// without a readable json report are logged and left out.
+func summarize(targets []TargetConfig, durations map[string]int64) []Summary {
+	var summaries []Summary
+
+	for _, t := range targets {
+		if t.Skip != "" {
+			summaries = append(summaries, Summary{Target: t.Name, Note: t.Skip, Annotation: t.VM.Annotation})
+			continue
+		}
+
+		s, err := readSummary(t.Name, reportFile(t, "json"))
+		if err != nil {
+			slog.Warn("no summary", "target", t.Name, "err", err)
+			continue
+		}
+		s.DurationMs = durations[t.Name]
+		s.Annotation = t.VM.Annotation
+
+		summaries = append(summaries, s)
+	}
+
+	return summaries
+}
+
+// newJSONSummary builds the --summary-json output for the scanned targets.
+func newJSONSummary(connectedTo, version string, targets []TargetConfig, summaries []Summary) JSONSummary {
+	js := JSONSummary{
+		ConnectedTo:    connectedTo,
+		VCenterVersion: version,
+		Targets:        []JSONTargetSummary{},
+	}
+
+	byName := map[string]Summary{}
+	for _, s := range summaries {
+		byName[s.Target] = s
+	}
+
+	for _, t := range targets {
+		ts := JSONTargetSummary{
+			Name:    t.Name,
+			IP:      targetHost(t),
+			Profile: t.Profile,
+		}
+
+		s, ok := byName[t.Name]
+		if !ok {
+			js.Totals.Errored++
+		}
+
+		ts.Passed, ts.Failed, ts.Skipped, ts.Note = s.Passed, s.Failed, s.Skipped, s.Note
+		ts.Waived = s.Waived
+		ts.DurationMs = s.DurationMs
+		ts.Annotation = t.VM.Annotation
+
+		js.Totals.Targets++
+		js.Totals.Passed += ts.Passed
+		js.Totals.Failed += ts.Failed
+		js.Totals.Skipped += ts.Skipped
+		js.Totals.Waived += ts.Waived
+		if t.Skip == skipNoTools {
+			js.Totals.SkippedNoTools++
+		}
+		js.Targets = append(js.Targets, ts)
+	}
+
+	return js
+}
+
+// printJSONSummary writes s as indented JSON to stdout.
+func printJSONSummary(s JSONSummary) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(s)
+}
+
+// printSummaries prints a table of target compliance.
+func printSummaries(summaries []Summary) {
+	fmt.Fprintf(humanOut, "\nCompliance summary\n\n")
+	w := new(tabwriter.Writer)
+	w.Init(humanOut, 0, 8, 1, '\t', 0)
+
+	fmt.Fprintf(w, "TARGET\tPASSED\tFAILED\tSKIPPED\tWAIVED\tSCORE\tDURATION\tNOTE\tANNOTATION\n")
+	var noTools int
+	for _, s := range summaries {
+		// targets not scanned in this run have no duration
+		duration := "-"
+		if s.DurationMs > 0 {
+			duration = (time.Duration(s.DurationMs) * time.Millisecond).Round(100 * time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.1f%%\t%s\t%s\t%s\n", s.Target, s.Passed, s.Failed, s.Skipped, s.Waived, s.ComplianceScore, duration, s.Note, truncate(s.Annotation, maxAnnotationWidth))
+		if s.Note == skipNoTools {
+			noTools++
+		}
+	}
+
+	w.Flush()
+
+	if noTools > 0 {
+		fmt.Fprintf(humanOut, "\n%d targets skipped without VMware Tools\n", noTools)
+	}
+}
+
+// maxAnnotationWidth is how much of a VM's annotation the summary table
+// shows; the JSON summary has all of it.
+const maxAnnotationWidth = 40
+
+// truncate returns the first line of s, cut to max runes with an ellipsis
+// if it's longer.
+func truncate(s string, max int) string {
+	line, _, more := strings.Cut(strings.TrimSpace(s), "\n")
+	r := []rune(strings.TrimSpace(line))
+	if len(r) <= max && !more {
+		return string(r)
+	}
+	if len(r) > max-1 {
+		r = r[:max-1]
+	}
+
+	return string(r) + "…"
+}
+
+// shouldFail reports whether the run should exit non-zero under policy.
+// The skipped policy is the stricter one: failed controls trip it too.
+func shouldFail(summaries []Summary, policy string) bool {
+	for _, s := range summaries {
+		switch policy {
+		case FailOnFailed:
+			if s.Failed > 0 {
+				return true
+			}
+		case FailOnSkipped:
+			if s.Failed > 0 || s.Skipped > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package scanner
+
+import "testing"
+
+func TestShouldFail(t *testing.T) {
+	clean := Summary{Target: "clean", Passed: 3}
+	failed := Summary{Target: "failed", Passed: 2, Failed: 1}
+	skipped := Summary{Target: "skipped", Passed: 2, Skipped: 1}
+
+	tests := []struct {
+		name      string
+		summaries []Summary
+		policy    string
+		want      bool
+	}{
+		{"no targets", nil, FailOnFailed, false},
+		{"all passed", []Summary{clean}, FailOnFailed, false},
+		{"failed on failed", []Summary{clean, failed}, FailOnFailed, true},
+		{"skipped on failed", []Summary{clean, skipped}, FailOnFailed, false},
+		{"skipped on skipped", []Summary{clean, skipped}, FailOnSkipped, true},
+		{"failed on skipped", []Summary{failed}, FailOnSkipped, true},
+		{"all passed on skipped", []Summary{clean}, FailOnSkipped, false},
+		{"failed on none", []Summary{failed, skipped}, FailOnNone, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldFail(tt.summaries, tt.policy); got != tt.want {
+				t.Errorf("shouldFail(%v, %q) = %v, want %v", tt.summaries, tt.policy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSummary(t *testing.T) {
+	tests := []struct {
+		file string
+		want Summary
+	}{
+		{"../result/testdata/linux-baseline.json", Summary{Target: "web", Passed: 1, Failed: 1, Skipped: 1, ComplianceScore: 50}},
+		{"../result/testdata/waivers-example.json", Summary{Target: "web", Passed: 1, Failed: 1, Waived: 2, ComplianceScore: 50}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := readSummary("web", tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("readSummary = %+v, want %+v", got, tt.want)
+			}
+
+			// the combined report must count the controls the same way
+			r, err := readReport(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Passed != got.Passed || r.Failed != got.Failed || r.Skipped != got.Skipped || r.Waived != got.Waived {
+				t.Errorf("readReport counts %d/%d/%d/%d, summary %d/%d/%d/%d", r.Passed, r.Failed, r.Skipped, r.Waived, got.Passed, got.Failed, got.Skipped, got.Waived)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runDirPrefix and runDirLayout name the directory of a run under
+// --report-dir with --report-run-dir. Only directories named like this are
+// ever pruned.
+const (
+	runDirPrefix = "run-"
+	runDirLayout = "20060102T150405Z"
+)
+
+// runDirName returns the name of the report directory of a run started at
+// started.
+func runDirName(started time.Time) string {
+	return runDirPrefix + started.UTC().Format(runDirLayout)
+}
+
+// retention is a --report-retention policy: keep the newest count run
+// directories, or those younger than age.
+type retention struct {
+	count int
+	age   time.Duration
+}
+
+// parseRetention parses a --report-retention value, a number of runs or a
+// duration such as 720h.
+func parseRetention(s string) (retention, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 {
+			return retention{}, errors.New("must keep at least 1 run")
+		}
+		return retention{count: n}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return retention{}, errors.New("neither a number of runs nor a duration")
+	}
+	if d <= 0 {
+		return retention{}, errors.New("duration must be positive")
+	}
+
+	return retention{age: d}, nil
+}
+
+// pruneRunDirs removes the run directories under base that r doesn't keep,
+// as of now, logging each one. The directory of the current run is always
+// kept, and anything not named like a run directory left alone.
+func pruneRunDirs(base string, r retention, now time.Time, current string) error {
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return err
+	}
+
+	type runDir struct {
+		name    string
+		started time.Time
+	}
+
+	var dirs []runDir
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), runDirPrefix) {
+			continue
+		}
+
+		started, err := time.Parse(runDirLayout, strings.TrimPrefix(e.Name(), runDirPrefix))
+		if err != nil {
+			continue
+		}
+
+		dirs = append(dirs, runDir{name: e.Name(), started: started})
+	}
+
+	// newest first
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].started.After(dirs[j].started) })
+
+	for i, d := range dirs {
+		if d.name == current {
+			continue
+		}
+
+		switch {
+		case r.count > 0 && i < r.count:
+			continue
+		case r.age > 0 && now.Sub(d.started) <= r.age:
+			continue
+		}
+
+		path := filepath.Join(base, d.name)
+		slog.Info("removing old report directory", "dir", path, "started", d.started)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,313 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a single scan.
+type Result struct {
+	Target   string
+	Profile  string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+
+	// DurationMs is the wall-clock time of the scan in milliseconds
+	DurationMs int64
+}
+
+// ResultCollector gathers the Results of scans running concurrently, and
+// the errors of those that failed. The zero value is ready to use.
+type ResultCollector struct {
+	mu      sync.Mutex
+	results []Result
+	errs    []ScanError
+}
+
+// Add records res, and err if the scan failed.
+func (c *ResultCollector) Add(res Result, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results = append(c.results, res)
+	if err != nil {
+		c.errs = append(c.errs, execError(res.Target, err))
+	}
+}
+
+// All returns a copy of the results added so far, in the order they were.
+func (c *ResultCollector) All() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.results)
+}
+
+// Errors returns a copy of the errors added so far, in the order they were.
+func (c *ResultCollector) Errors() []ScanError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.errs)
+}
+
+// Runner runs a compliance profile against a single target.
+type Runner interface {
+	Run(ctx context.Context, cfg TargetConfig, profile string) (Result, error)
+}
+
+// CLIRunner runs scans with the inspec command line tool, killing any
+// scan that takes longer than Timeout, if it is set. Profiles are resolved relative to
+// ProfilesPath. ExtraArgs are passed on to inspec exec as they are.
+type CLIRunner struct {
+	Bin          string
+	ProfilesPath string
+	Timeout      time.Duration
+	ExtraArgs    []string
+}
+
+// withTimeout is context.WithTimeout, except that a timeout of 0 or less
+// is no limit.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Args returns the inspec arguments used to scan with profile.
+func (r CLIRunner) Args(profile string) []string {
+	return append([]string{"exec", r.profilePath(profile), "--json-config=-"}, r.ExtraArgs...)
+}
+
+// reservedArgs are the inspec exec options set from the target's config,
+// which an --inspec-arg mustn't set again.
+var reservedArgs = []string{"--json-config", "--config", "--reporter", "--target", "-t"}
+
+// extraArgs returns args without the reserved options and the profiles,
+// which are already passed to inspec, warning about every one dropped.
+func extraArgs(args []string, profiles []string) []string {
+	var out []string
+	for _, a := range args {
+		name, _, _ := strings.Cut(a, "=")
+		if slices.Contains(reservedArgs, name) || slices.Contains(profiles, a) {
+			slog.Warn("ignoring --inspec-arg that is set already", "arg", a)
+			continue
+		}
+		out = append(out, a)
+	}
+
+	return out
+}
+
+// profilePath returns where inspec finds profile. Absolute paths, git URLs
+// and other remote profiles are passed on as they are.
+func (r CLIRunner) profilePath(profile string) string {
+	if filepath.IsAbs(profile) || isRemoteProfile(profile) {
+		return profile
+	}
+
+	return filepath.Join(r.ProfilesPath, profile)
+}
+
+func (r CLIRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	res := Result{Target: t.Name, Profile: profile}
+
+	conf, err := json.Marshal(t)
+	if err != nil {
+		return res, err
+	}
+
+	args := r.Args(profile)
+
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	cmd := newInspecCmd(ctx, r.Bin, args)
+	cmd.Stdin = bytes.NewBuffer(conf)
+
+	slog.Debug("running inspec", "target", t.Name, "ip", t.Target, "cmd", r.Bin+" "+strings.Join(args, " "))
+	var out bytes.Buffer
+	stderr := &tailBuffer{max: maxStderr}
+	cmd.Stdout = &out
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	res.ExitCode = cmd.ProcessState.ExitCode()
+	res.Stdout = out.String()
+	res.Stderr = stderr.String()
+
+	// failed and skipped controls are results, not errors
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && scanCompleted(res.ExitCode) {
+		return res, nil
+	}
+
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return res, fmt.Errorf("timed out after %s: %w", r.Timeout, ctx.Err())
+		}
+		return res, fmt.Errorf("%s: %s", err, stderrTail(res.Stderr))
+	}
+
+	return res, nil
+}
+
+// InSpec exit codes of scans that ran to the end
+const (
+	inspecExitFailed  = 100
+	inspecExitSkipped = 101
+)
+
+// scanCompleted reports whether inspec exiting with code ran every control,
+// whether they passed, failed or were skipped. Any other code means the
+// scan itself went wrong.
+func scanCompleted(code int) bool {
+	switch code {
+	case 0, inspecExitFailed, inspecExitSkipped:
+		return true
+	}
+
+	return false
+}
+
+// maxStderr bounds the stderr kept of a scan. Only the end is kept, which
+// is where inspec reports what went wrong.
+const maxStderr = 1 << 20
+
+// tailBuffer is a writer keeping the last max bytes written to it.
+type tailBuffer struct {
+	max     int
+	buf     []byte
+	dropped int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if n := len(b.buf) - b.max; n > 0 {
+		b.dropped += n
+		b.buf = append(b.buf[:0], b.buf[n:]...)
+	}
+
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	if b.dropped > 0 {
+		return fmt.Sprintf("[%d bytes dropped]\n%s", b.dropped, b.buf)
+	}
+
+	return string(b.buf)
+}
+
+// stderrTailLines is how many lines of stderr a failed scan reports.
+const stderrTailLines = 5
+
+// stderrTail returns the last lines of stderr on one line, to fit in the
+// table of failed scans.
+func stderrTail(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if len(lines) > stderrTailLines {
+		lines = append([]string{"..."}, lines[len(lines)-stderrTailLines:]...)
+	}
+
+	return strings.Join(lines, " | ")
+}
+
+// stderrRunner saves the stderr of every scan to <name>.stderr.log in dir.
+type stderrRunner struct {
+	Runner
+	dir string
+}
+
+func (r stderrRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	res, err := r.Runner.Run(ctx, t, profile)
+
+	path := filepath.Join(r.dir, outputName(t)+".stderr.log")
+	if werr := os.WriteFile(path, []byte(res.Stderr), 0600); werr != nil {
+		slog.Warn("can't save stderr", "target", t.Name, "path", path, "err", werr)
+	}
+
+	return res, err
+}
+
+// transientErrors are stderr fragments that mean inspec couldn't reach the
+// target, as opposed to the target failing its controls.
+var transientErrors = []string{
+	"connection refused",
+	"connection timed out",
+	"connection reset",
+	"no route to host",
+	"host unreachable",
+	"econnrefused",
+	"etimedout",
+	"ehostunreach",
+	"connectiontimeout",
+	"connection closed by remote host",
+}
+
+// isTransient reports whether stderr from a failed scan looks like a
+// connection problem worth retrying.
+func isTransient(stderr string) bool {
+	stderr = strings.ToLower(stderr)
+	for _, e := range transientErrors {
+		if strings.Contains(stderr, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryRunner retries scans that failed to connect, doubling the wait
+// between attempts. Scans that ran, whatever their outcome, are never
+// retried.
+type retryRunner struct {
+	Runner
+	maxRetries int
+	backoff    time.Duration
+}
+
+func (r retryRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	wait := r.backoff
+
+	for attempt := 0; ; attempt++ {
+		res, err := r.Runner.Run(ctx, t, profile)
+		if err == nil || attempt >= r.maxRetries || ctx.Err() != nil || !isTransient(res.Stderr) {
+			return res, err
+		}
+
+		slog.Info("retrying scan", "target", t.Name, "attempt", attempt+1, "wait", wait, "err", err)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return res, err
+		}
+
+		wait *= 2
+	}
+}
+
+// newInspecCmd returns an inspec command that is killed when ctx is done.
+// WaitDelay makes sure Run returns even if a grandchild is still holding
+// on to stdout/stderr after inspec itself has been killed.
+func newInspecCmd(ctx context.Context, bin string, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.WaitDelay = 10 * time.Second
+
+	return cmd
+}
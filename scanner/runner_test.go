@@ -0,0 +1,112 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeInspec writes a script to dir that stands in for inspec, printing
+// stderr and exiting with code.
+func fakeInspec(t *testing.T, dir string, code int, stderr string) string {
+	t.Helper()
+
+	bin := filepath.Join(dir, fmt.Sprintf("inspec-%d", code))
+	script := fmt.Sprintf("#!/bin/sh\ncat >/dev/null\necho '%s' >&2\nexit %d\n", stderr, code)
+	if err := os.WriteFile(bin, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return bin
+}
+
+func TestCLIRunnerExitCodes(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		code      int
+		completed bool
+	}{
+		{0, true},
+		{inspecExitFailed, true},
+		{inspecExitSkipped, true},
+		{1, false},
+		{2, false},
+		{3, false},
+		{172, false},
+	}
+	for _, tt := range tests {
+		if got := scanCompleted(tt.code); got != tt.completed {
+			t.Errorf("scanCompleted(%d) = %v, want %v", tt.code, got, tt.completed)
+		}
+
+		r := CLIRunner{Bin: fakeInspec(t, dir, tt.code, "boom"), ProfilesPath: dir}
+		res, err := r.Run(context.Background(), TargetConfig{Name: "web-1"}, "linux-baseline")
+		if res.ExitCode != tt.code {
+			t.Errorf("exit %d: result exit code = %d", tt.code, res.ExitCode)
+		}
+		if (err == nil) != tt.completed {
+			t.Errorf("exit %d: err = %v, want completed %v", tt.code, err, tt.completed)
+		}
+		if res.Stderr != "boom\n" {
+			t.Errorf("exit %d: stderr = %q, want boom", tt.code, res.Stderr)
+		}
+	}
+}
+
+func TestResultCollectorConcurrent(t *testing.T) {
+	const workers, each = 16, 100
+
+	var c ResultCollector
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				// every other scan of every other worker fails
+				var err error
+				if w%2 == 0 && i%2 == 0 {
+					err = context.DeadlineExceeded
+				}
+
+				c.Add(Result{Target: fmt.Sprintf("web-%d-%d", w, i)}, err)
+				_ = c.All()
+				_ = c.Errors()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	all := c.All()
+	if len(all) != workers*each {
+		t.Fatalf("collected %d results, want %d", len(all), workers*each)
+	}
+
+	seen := map[string]bool{}
+	for _, res := range all {
+		if seen[res.Target] {
+			t.Errorf("result for %s collected twice", res.Target)
+		}
+		seen[res.Target] = true
+	}
+
+	errs := c.Errors()
+	if len(errs) != workers*each/4 {
+		t.Fatalf("collected %d errors, want %d", len(errs), workers*each/4)
+	}
+	for _, e := range errs {
+		if !seen[e.Target] || e.Stage != stageTimeout {
+			t.Errorf("error %+v isn't of a collected result, or not a timeout", e)
+		}
+	}
+
+	all[0].Target = "changed"
+	errs[0].Target = "changed"
+	if c.All()[0].Target == "changed" || c.Errors()[0].Target == "changed" {
+		t.Error("the collector returned its own slices")
+	}
+}
@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// sarifLog is the root of a SARIF 2.1.0 log.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+// sarifRule describes a control.
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+// sarifResult is a control failed on a single target.
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	LogicalLocations []sarifLogical        `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogical struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// sarifLevel maps an InSpec control impact to a SARIF level, the way
+// InSpec names them: high and critical are errors, medium warnings, and
+// low notes.
+func sarifLevel(impact float64) string {
+	switch {
+	case impact >= 0.7:
+		return "error"
+	case impact >= 0.4:
+		return "warning"
+	}
+
+	return "note"
+}
+
+// newSARIFLog converts the failed controls in the json reports of targets
+// into SARIF, with the control id as the rule and the target as the
+// location. Targets that were skipped or have no readable report are left
+// out.
+func newSARIFLog(targets []TargetConfig) sarifLog {
+	driver := sarifDriver{Name: "InSpec", InformationURI: "https://www.inspec.io", Rules: []sarifRule{}}
+	results := []sarifResult{}
+	rules := map[string]bool{}
+
+	for _, t := range targets {
+		if t.Skip != "" {
+			continue
+		}
+
+		controls, _, err := readControls(reportFile(t, "json"))
+		if err != nil {
+			slog.Warn("no report for SARIF", "target", t.Name, "err", err)
+			continue
+		}
+
+		for _, c := range controls {
+			if c.Status != statusFailed {
+				continue
+			}
+
+			if !rules[c.ID] {
+				rules[c.ID] = true
+				driver.Rules = append(driver.Rules, sarifRule{ID: c.ID, ShortDescription: sarifMessage{Text: c.Title}})
+			}
+
+			text := fmt.Sprintf("%s failed on %s", c.ID, t.Name)
+			if c.Title != "" {
+				text = fmt.Sprintf("%s: %s failed on %s", c.ID, c.Title, t.Name)
+			}
+
+			results = append(results, sarifResult{
+				RuleID:  c.ID,
+				Level:   sarifLevel(c.Impact),
+				Message: sarifMessage{Text: text},
+				Locations: []sarifLocation{{
+					PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: t.Target}},
+					LogicalLocations: []sarifLogical{{FullyQualifiedName: t.Name, Kind: "resource"}},
+				}},
+			})
+		}
+	}
+
+	return sarifLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}
+}
+
+// writeSARIFReport writes the SARIF log of targets to path.
+func writeSARIFReport(path string, targets []TargetConfig) error {
+	b, err := json.MarshalIndent(newSARIFLog(targets), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b)
+}
@@ -0,0 +1,113 @@
+package scanner
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestSARIFLog(t *testing.T) {
+	targets := []TargetConfig{
+		{Name: "web-1", Target: "ssh://10.0.0.5", Reporter: buildReporter([]string{"json"}, "../result/testdata/linux-baseline")},
+		{Name: "web-2", Target: "ssh://10.0.0.6", Reporter: buildReporter([]string{"json"}, "../result/testdata/waivers-example")},
+		{Name: "web-3", Skip: skipNotPoweredOn},
+	}
+
+	b, err := json.Marshal(newSARIFLog(targets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check what the SARIF 2.1.0 schema requires of the log, read back
+	// without the types that wrote it
+	var log struct {
+		Schema  string `json:"$schema"`
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string `json:"name"`
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID  string `json:"ruleId"`
+				Level   string `json:"level"`
+				Message struct {
+					Text string `json:"text"`
+				} `json:"message"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(b, &log); err != nil {
+		t.Fatal(err)
+	}
+
+	if log.Schema != sarifSchema || log.Version != "2.1.0" {
+		t.Errorf("schema %q version %q, want %q 2.1.0", log.Schema, log.Version, sarifSchema)
+	}
+	if len(log.Runs) != 1 || log.Runs[0].Tool.Driver.Name == "" {
+		t.Fatalf("want a single run with a named tool, got %s", b)
+	}
+	run := log.Runs[0]
+
+	// only the failed controls are results, not the waived ones
+	type result struct{ rule, level, uri string }
+	var got []result
+	for _, r := range run.Results {
+		if r.Message.Text == "" {
+			t.Errorf("result %s has no message", r.RuleID)
+		}
+		if !slices.Contains([]string{"none", "note", "warning", "error"}, r.Level) {
+			t.Errorf("result %s has level %q", r.RuleID, r.Level)
+		}
+		if len(r.Locations) != 1 {
+			t.Fatalf("result %s has %d locations, want 1", r.RuleID, len(r.Locations))
+		}
+		got = append(got, result{r.RuleID, r.Level, r.Locations[0].PhysicalLocation.ArtifactLocation.URI})
+	}
+
+	want := []result{
+		{"os-02", "error", "ssh://10.0.0.5"},
+		{"ssh-04", "error", "ssh://10.0.0.6"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+
+	var rules []string
+	for _, r := range run.Tool.Driver.Rules {
+		rules = append(rules, r.ID)
+	}
+	if !slices.Equal(rules, []string{"os-02", "ssh-04"}) {
+		t.Errorf("rules = %q, want os-02 and ssh-04", rules)
+	}
+}
+
+func TestSARIFLevel(t *testing.T) {
+	tests := []struct {
+		impact float64
+		want   string
+	}{
+		{1.0, "error"},
+		{0.7, "error"},
+		{0.5, "warning"},
+		{0.3, "note"},
+		{0, "note"},
+	}
+
+	for _, tt := range tests {
+		if got := sarifLevel(tt.impact); got != tt.want {
+			t.Errorf("sarifLevel(%v) = %q, want %q", tt.impact, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,998 @@
+// Package scanner scans the VMs and ESXi hosts of a vCenter, or of
+// standalone ESXi hosts, with InSpec. It is what the vmware-poc command
+// runs, for programs that embed it:
+//
+//	s, err := scanner.New(cfg)
+//	defer s.Close()
+//	targets, err := s.Discover(ctx)
+//	report, err := s.Run(ctx, targets)
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/vim25"
+)
+
+// Exit codes of a run
+const (
+	ExitOK            = 0
+	ExitScanFailed    = 1
+	ExitConnectFailed = 2
+	ExitConfigFailed  = 3
+	ExitNonCompliant  = 4
+	ExitInterrupted   = 130
+)
+
+// Config configures a Scanner. Every field stands in for the vmware-poc
+// flag of the same name, lists for the repeatable ones; the flags document
+// what they do. The password flags are read into URL and ESXiPassword.
+// The zero value of a field is the flag's zero value, not its default.
+type Config struct {
+	// URL is the ESX or vCenter SDK URL, with the user and password to
+	// log in with unless there is a SessionToken
+	URL          *url.URL
+	Insecure     bool
+	Thumbprint   string
+	CACert       string
+	ClientCert   string
+	ClientKey    string
+	Proxy        string
+	SessionToken string
+	SessionCache bool
+	APIQPS       float64
+	MaxIdleConns int
+	Keepalive    time.Duration
+
+	// HostsFile lists standalone ESXi hosts to scan instead of a vCenter
+	HostsFile    string
+	ESXiUser     string
+	ESXiPassword string
+
+	// Credentials are the guest and host credentials, and CredentialChain
+	// credential sources tried in order instead. CredentialsFile replaces
+	// both, and VaultPath the chain.
+	Credentials     *CredentialStore
+	CredentialChain []CredentialSource
+	CredentialsFile string
+	VaultAddr       string
+	VaultPath       string
+
+	Datacenters      []string
+	Cluster          string
+	ResourcePool     string
+	Folder           string
+	FolderRecursive  bool
+	IncludeHosts     string
+	ExcludeHosts     string
+	Tags             []string
+	VMNames          []string
+	ExcludeVMNames   []string
+	SkipSystemVMs    bool
+	ScanTemplates    bool
+	PowerState       string
+	Since            string
+	TargetNetwork    string
+	PreferIPv6       bool
+	OnlyWithTools    bool
+	WaitForIP        time.Duration
+	MaxTargets       int
+	DiscoveryTimeout time.Duration
+	DiscoveryWorkers int
+
+	// Bastions and Ports are tried in order before BastionHost and Port
+	Transport    string
+	Bastions     []Bastion
+	BastionHost  string
+	BastionUser  string
+	BastionPort  int
+	Ports        []PortRule
+	Port         int
+	Sudo         bool
+	SudoPassword string
+	SudoOptions  string
+	WinRMSSL     bool
+
+	Mode            string
+	FactsDir        string
+	FactCommand     string
+	SnapshotBefore  bool
+	SnapshotCleanup bool
+
+	// Inputs are InSpec profile inputs, which InputFile replaces, and
+	// Waivers waiver files on top of WaiverFiles
+	InspecBin      string
+	InspecArgs     []string
+	GuestInspecBin string
+	ProfilesPath   string
+	ProfileMap     map[string]string
+	Profiles       []string
+	AutoDetect     bool
+	Inputs         map[string]interface{}
+	InputFile      string
+	WaiverFiles    []string
+	Waivers        []WaiverRule
+	LogLevel       string
+
+	Reporters       []string
+	ReportDir       string
+	ReportRunDir    bool
+	ReportRetention string
+	CombinedReport  string
+	Compress        bool
+	HTMLReport      string
+	JUnit           string
+	SARIF           string
+	SummaryJSON     bool
+	Quiet           bool
+	SaveStderr      bool
+	S3Bucket        string
+	S3Prefix        string
+	WebhookURL      string
+	WebhookFormat   string
+	MetricsAddr     string
+	AuditLog        string
+
+	CacheFile string
+	UseCache  bool
+	CacheTTL  time.Duration
+	Resume    bool
+	Force     bool
+
+	Concurrency         int
+	ConcurrencyAdaptive bool
+	MinConcurrency      int
+	FailureWindow       int
+	FailureThreshold    float64
+	WorkersPerHost      int
+	ScanTimeout         time.Duration
+	ShutdownGrace       time.Duration
+	MaxRetries          int
+	RetryBackoff        time.Duration
+	FailOn              string
+	FailFast            bool
+
+	// InventoryCSV, CheckConnectivity, Plan, DryRun and ValidateProfiles
+	// make Run do that instead of scanning
+	InventoryCSV      string
+	CheckConnectivity bool
+	Plan              bool
+	DryRun            bool
+	ValidateProfiles  bool
+}
+
+// Error is why New, Discover or Run gave up. It has been logged with its
+// details already.
+type Error struct {
+	// Code is the exit code of the run
+	Code int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// fail logs msg and args, and returns the Error ending the run with code.
+func fail(code int, msg string, args ...any) error {
+	slog.Error(msg, args...)
+	return &Error{Code: code, Msg: msg}
+}
+
+// Report is the outcome of a Run.
+type Report struct {
+	// Code is the exit code of the run
+	Code int
+
+	VCenter   string
+	Targets   []TargetConfig
+	Summaries []Summary
+	Errors    []ScanError
+}
+
+// Scanner discovers and scans the targets of a Config.
+type Scanner struct {
+	cfg     Config
+	started time.Time
+
+	audit      *auditLog
+	sink       ResultSink
+	metrics    *scanMetrics
+	metricsSrv *http.Server
+
+	runner      CLIRunner
+	opts        discoverOptions
+	profileList []string
+	inputs      map[string]interface{}
+	keep        retention
+	reportBase  string
+
+	// vc and errs are what Discover found, and failed at
+	vc   vcenterInfo
+	errs []ScanError
+}
+
+// New checks cfg and returns a Scanner for it. Close it when done.
+func New(cfg Config) (*Scanner, error) {
+	s := &Scanner{cfg: cfg, started: time.Now()}
+	if err := s.setup(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close closes the audit log and stops serving metrics.
+func (s *Scanner) Close() error {
+	// cleanup still has to work after an interrupt
+	cleanupCtx := context.Background()
+
+	if s.metricsSrv != nil {
+		s.metricsSrv.Shutdown(cleanupCtx)
+	}
+
+	if s.audit != nil {
+		if err := s.audit.Close(); err != nil {
+			slog.Error("can't close audit log", "path", s.cfg.AuditLog, "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setup checks the config and sets up what discovery and scans need.
+func (s *Scanner) setup() error {
+	cfg := &s.cfg
+
+	apiLimiter = newAPILimiter(cfg.APIQPS)
+
+	if cfg.AuditLog != "" {
+		var err error
+		s.audit, err = openAuditLog(cfg.AuditLog)
+		if err != nil {
+			return fail(ExitConfigFailed, "can't open audit log", "path", cfg.AuditLog, "err", err)
+		}
+	}
+	s.audit.Log(auditEvent{Event: auditRunStarted, Time: s.started.UTC()})
+
+	// keep stdout clean for the json summary or plan
+	switch {
+	case cfg.Quiet:
+		humanOut = io.Discard
+		progressOut = io.Discard
+	case cfg.SummaryJSON, cfg.Plan:
+		humanOut = os.Stderr
+	}
+
+	if cfg.Credentials != nil {
+		if err := cfg.Credentials.compile("credentials"); err != nil {
+			return fail(ExitConfigFailed, "bad credentials", "err", err)
+		}
+	}
+	for _, src := range cfg.CredentialChain {
+		if src.Credentials != nil {
+			if err := src.Credentials.compile("credential-chain"); err != nil {
+				return fail(ExitConfigFailed, "bad credential chain", "err", err)
+			}
+		}
+	}
+
+	creds := cfg.Credentials
+	if cfg.CredentialsFile != "" {
+		var err error
+		creds, err = LoadCredentialStore(cfg.CredentialsFile)
+		if err != nil {
+			return fail(ExitConfigFailed, "can't load credentials", "err", err)
+		}
+	}
+
+	// --credentials and --vault-path replace the chain of the config file
+	var secrets SecretProvider
+	switch {
+	case cfg.VaultPath != "":
+		var err error
+		secrets, err = newVaultProvider(cfg.VaultAddr, cfg.VaultPath)
+		if err != nil {
+			return fail(ExitConfigFailed, "can't set up vault", "err", err)
+		}
+	case cfg.CredentialsFile == "" && len(cfg.CredentialChain) > 0:
+		var err error
+		secrets, err = newChainProvider(cfg.CredentialChain)
+		if err != nil {
+			return fail(ExitConfigFailed, "bad credential chain", "err", err)
+		}
+	}
+
+	s.sink = localSink{}
+	if cfg.S3Bucket != "" {
+		// only reads the AWS config from the environment and files
+		var err error
+		s.sink, err = newS3Sink(context.Background(), cfg.S3Bucket, cfg.S3Prefix)
+		if err != nil {
+			return fail(ExitConfigFailed, "can't set up S3", "err", err)
+		}
+	}
+
+	if cfg.MetricsAddr != "" {
+		var err error
+		s.metrics, s.metricsSrv, err = serveMetrics(cfg.MetricsAddr)
+		if err != nil {
+			return fail(ExitConfigFailed, "can't serve metrics", "err", err)
+		}
+	}
+
+	s.inputs = cfg.Inputs
+	if cfg.InputFile != "" {
+		var err error
+		s.inputs, err = loadInputs(cfg.InputFile)
+		if err != nil {
+			return fail(ExitConfigFailed, "can't load inputs", "err", err)
+		}
+	}
+
+	// catch bad templates before anything is scanned
+	if _, err := renderInputs(s.inputs, inputVars{}); err != nil {
+		return fail(ExitConfigFailed, "bad inputs", "err", err)
+	}
+
+	if !slices.Contains(FailOnPolicies, cfg.FailOn) {
+		return fail(ExitConfigFailed, "bad --fail-on", "policy", cfg.FailOn, "valid", FailOnPolicies)
+	}
+
+	if cfg.FailFast && cfg.FailOn == FailOnNone {
+		return fail(ExitConfigFailed, "--fail-fast needs a --fail-on policy other than none")
+	}
+
+	if cfg.FailureThreshold < 0 || cfg.FailureThreshold > 1 {
+		return fail(ExitConfigFailed, "bad --failure-threshold, it's a share between 0 and 1", "threshold", cfg.FailureThreshold)
+	}
+
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return fail(ExitConfigFailed, "--client-cert and --client-key go together", "client-cert", cfg.ClientCert, "client-key", cfg.ClientKey)
+	}
+
+	if !slices.Contains(ScanModes, cfg.Mode) {
+		return fail(ExitConfigFailed, "bad --mode", "mode", cfg.Mode, "valid", ScanModes)
+	}
+
+	if cfg.Mode == ModeOffline && cfg.FactsDir == "" && cfg.FactCommand == "" {
+		return fail(ExitConfigFailed, "--mode=offline needs --facts-dir or --fact-command")
+	}
+
+	if !slices.Contains(powerStates, cfg.PowerState) {
+		return fail(ExitConfigFailed, "bad --power-state", "state", cfg.PowerState, "valid", powerStates)
+	}
+
+	if !slices.Contains(GuestTransports, cfg.Transport) {
+		return fail(ExitConfigFailed, "bad --transport", "transport", cfg.Transport, "valid", GuestTransports)
+	}
+
+	var since time.Time
+	if cfg.Since != "" {
+		var err error
+		if since, err = parseSince(cfg.Since, s.started); err != nil {
+			return fail(ExitConfigFailed, "bad --since", "err", err)
+		}
+	}
+
+	if cfg.TargetNetwork != "" {
+		if _, _, err := net.ParseCIDR(cfg.TargetNetwork); err != nil {
+			return fail(ExitConfigFailed, "bad --target-network", "err", err)
+		}
+	}
+
+	if err := validPatterns(cfg.VMNames); err != nil {
+		return fail(ExitConfigFailed, "bad --vm-name", "err", err)
+	}
+
+	if err := validPatterns(cfg.ExcludeVMNames); err != nil {
+		return fail(ExitConfigFailed, "bad --exclude-vm-name", "err", err)
+	}
+
+	if cfg.HostsFile != "" && len(cfg.Tags) > 0 {
+		return fail(ExitConfigFailed, "--tag needs vCenter, it can't be used with --hosts-file")
+	}
+
+	if cfg.HostsFile != "" && cfg.Transport == TransportGuestOps {
+		return fail(ExitConfigFailed, "--transport=guestops needs vCenter, it can't be used with --hosts-file")
+	}
+
+	if cfg.HostsFile != "" && cfg.SnapshotBefore {
+		return fail(ExitConfigFailed, "--snapshot-before needs vCenter, it can't be used with --hosts-file")
+	}
+
+	if cfg.HostsFile != "" && cfg.ScanTemplates {
+		return fail(ExitConfigFailed, "--scan-templates needs vCenter, it can't be used with --hosts-file")
+	}
+
+	if cfg.SnapshotCleanup && !cfg.SnapshotBefore {
+		return fail(ExitConfigFailed, "--snapshot-cleanup needs --snapshot-before")
+	}
+
+	if cfg.ReportRetention != "" {
+		var err error
+		if s.keep, err = parseRetention(cfg.ReportRetention); err != nil {
+			return fail(ExitConfigFailed, "bad --report-retention", "retention", cfg.ReportRetention, "err", err)
+		}
+		if !cfg.ReportRunDir {
+			return fail(ExitConfigFailed, "--report-retention needs --report-run-dir")
+		}
+	}
+
+	if cfg.ReportRunDir && cfg.Resume {
+		return fail(ExitConfigFailed, "--report-run-dir can't be used with --resume, every run starts a new directory")
+	}
+
+	// the reports of the run go in a directory of its own
+	s.reportBase = cfg.ReportDir
+	if cfg.ReportRunDir {
+		cfg.ReportDir = filepath.Join(s.reportBase, runDirName(s.started))
+	}
+
+	if cfg.ReportDir != "" {
+		if err := os.MkdirAll(cfg.ReportDir, 0755); err != nil {
+			return fail(ExitConfigFailed, "can't create report dir", "err", err)
+		}
+	}
+
+	if cfg.UseCache && cfg.CacheFile == "" {
+		return fail(ExitConfigFailed, "--use-cache needs --cache-file")
+	}
+
+	if !slices.Contains(WebhookFormats, cfg.WebhookFormat) {
+		return fail(ExitConfigFailed, "bad --webhook-format", "format", cfg.WebhookFormat, "valid", WebhookFormats)
+	}
+
+	var hosts hostFilter
+	var err error
+	if hosts.include, err = newHostMatcher(cfg.IncludeHosts); err != nil {
+		return fail(ExitConfigFailed, "bad --include-hosts", "err", err)
+	}
+	if hosts.exclude, err = newHostMatcher(cfg.ExcludeHosts); err != nil {
+		return fail(ExitConfigFailed, "bad --exclude-hosts", "err", err)
+	}
+
+	bastions, err := compileBastions(cfg.Bastions)
+	if err != nil {
+		return fail(ExitConfigFailed, "bad bastions", "err", err)
+	}
+	if cfg.BastionHost != "" {
+		bastions = append(bastions, Bastion{Host: cfg.BastionHost, User: cfg.BastionUser, Port: cfg.BastionPort})
+	}
+
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fail(ExitConfigFailed, "bad --port", "port", cfg.Port)
+	}
+	ports, err := compilePorts(cfg.Ports)
+	if err != nil {
+		return fail(ExitConfigFailed, "bad ports", "err", err)
+	}
+
+	if err := checkWaivers(cfg.WaiverFiles, cfg.Waivers); err != nil {
+		return fail(ExitConfigFailed, "bad waivers", "err", err)
+	}
+
+	for k, p := range cfg.ProfileMap {
+		if k == "" || slices.Contains(strings.Split(p, profileSep), "") {
+			return fail(ExitConfigFailed, "bad profile map", "err", fmt.Errorf("invalid profile mapping %q", k+"="+p))
+		}
+	}
+
+	// a local --profile is relative to the working directory, not to
+	// --profiles-path
+	for _, p := range cfg.Profiles {
+		if _, err := os.Stat(p); err == nil {
+			if p, err = filepath.Abs(p); err != nil {
+				return fail(ExitConfigFailed, "bad --profile", "err", err)
+			}
+		}
+		s.profileList = append(s.profileList, p)
+	}
+	profile := strings.Join(s.profileList, profileSep)
+
+	// the profiles of the map could be any target's
+	reserved := append([]string{vsphereProfile}, s.profileList...)
+	for _, p := range cfg.ProfileMap {
+		reserved = append(reserved, strings.Split(p, profileSep)...)
+	}
+
+	s.runner = CLIRunner{
+		Bin:          cfg.InspecBin,
+		ProfilesPath: cfg.ProfilesPath,
+		Timeout:      cfg.ScanTimeout,
+		ExtraArgs:    extraArgs(cfg.InspecArgs, reserved),
+	}
+
+	if !cfg.DryRun && !cfg.Plan && cfg.InventoryCSV == "" && !cfg.CheckConnectivity {
+		s.runner.Bin, err = exec.LookPath(cfg.InspecBin)
+		if err != nil {
+			return fail(ExitConfigFailed, "can't find inspec binary", "bin", cfg.InspecBin, "err", err)
+		}
+	}
+
+	s.opts = discoverOptions{
+		datacenters:      cfg.Datacenters,
+		creds:            creds,
+		secrets:          secrets,
+		profiles:         cfg.ProfileMap,
+		hosts:            hosts,
+		vmNames:          cfg.VMNames,
+		excludeVMNames:   cfg.ExcludeVMNames,
+		skipSystemVMs:    cfg.SkipSystemVMs,
+		scanTemplates:    cfg.ScanTemplates,
+		cluster:          cfg.Cluster,
+		resourcePool:     cfg.ResourcePool,
+		folder:           cfg.Folder,
+		folderRecursive:  cfg.FolderRecursive,
+		discoveryWorkers: cfg.DiscoveryWorkers,
+		waitForIP:        cfg.WaitForIP,
+		powerState:       cfg.PowerState,
+		profile:          profile,
+		transport:        cfg.Transport,
+		targetNetwork:    cfg.TargetNetwork,
+		onlyWithTools:    cfg.OnlyWithTools,
+		inventoryOnly:    cfg.InventoryCSV != "",
+		since:            since,
+		autoDetect:       cfg.AutoDetect && !cfg.DryRun && !cfg.Plan && !cfg.CheckConnectivity,
+		preferIPv6:       cfg.PreferIPv6,
+		winrmSSL:         cfg.WinRMSSL,
+		sudo:             cfg.Sudo,
+		sudoPassword:     cfg.SudoPassword,
+		sudoOptions:      cfg.SudoOptions,
+		bastions:         bastions,
+		ports:            ports,
+		port:             cfg.Port,
+		esxiUser:         cfg.ESXiUser,
+		esxiPassword:     cfg.ESXiPassword,
+		logLevel:         cfg.LogLevel,
+	}
+
+	return nil
+}
+
+// Discover finds the targets to scan: the VMs and hosts of vCenter, of the
+// --hosts-file hosts or of the --cache-file. They are returned in the
+// order they are scanned in, once per profile, with their reports set up.
+// What fails for a single target is reported by Run. With
+// ValidateProfiles there is nothing to discover.
+func (s *Scanner) Discover(ctx context.Context) ([]TargetConfig, error) {
+	cfg := s.cfg
+	opts := s.opts
+	s.errs = nil
+
+	// profiles are checked before going anywhere near vCenter
+	if cfg.ValidateProfiles {
+		return nil, nil
+	}
+
+	// a vCenter that hangs mustn't hang the run with it
+	dctx, dcancel := ctx, func() {}
+	if cfg.DiscoveryTimeout > 0 {
+		dctx, dcancel = context.WithTimeout(ctx, cfg.DiscoveryTimeout)
+	}
+	defer dcancel()
+
+	var targets []TargetConfig
+	var err error
+	switch {
+	case cfg.UseCache:
+		s.vc, targets, s.errs, err = loadTargetCache(cfg.CacheFile, cfg.CacheTTL, cfg.Force, opts)
+		if err != nil {
+			return nil, fail(ExitConfigFailed, "can't use target cache", "err", err)
+		}
+	case cfg.HostsFile != "":
+		esxiHosts, err := loadHostsFile(cfg.HostsFile)
+		if err != nil {
+			return nil, fail(ExitConfigFailed, "can't load hosts file", "err", err)
+		}
+
+		s.vc = vcenterInfo{Host: standaloneHost}
+		targets, s.errs = s.discoverESXi(dctx, esxiHosts, opts)
+	default:
+		s.vc, targets, s.errs, err = s.discover(dctx, opts)
+		if err != nil && dctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s: %s", cfg.DiscoveryTimeout, err)
+		}
+		if err != nil {
+			s.audit.Log(auditEvent{Event: auditError, Stage: stageConnect, Error: err.Error()})
+			return nil, fail(ExitConnectFailed, "discovery failed", "err", err)
+		}
+		s.audit.Log(auditEvent{Event: auditConnected, VCenter: s.vc.Host, Version: s.vc.Version})
+	}
+
+	// what was found before the timeout is only part of the inventory
+	if dctx.Err() == context.DeadlineExceeded {
+		s.audit.Log(auditEvent{Event: auditError, Stage: stageDiscovery, Error: dctx.Err().Error()})
+		return nil, fail(ExitConnectFailed, "discovery timed out, not scanning", "timeout", cfg.DiscoveryTimeout)
+	}
+	dcancel()
+
+	// a vm listed under more than one host would be scanned twice
+	targets = dedupeTargets(targets)
+
+	// an inventory needs no scanning, and isn't what the cache is for
+	if cfg.InventoryCSV != "" {
+		sortTargets(targets)
+		return targets, nil
+	}
+
+	// the guests whose guest family has no profile are asked what they are
+	if opts.autoDetect {
+		path := filepath.Join(cfg.ReportDir, "detect.json")
+
+		// detections are only reused when resuming, as guests may have
+		// been reinstalled since
+		detections := map[string]detection{}
+		if cfg.Resume {
+			if detections, err = loadDetections(path); err != nil {
+				return nil, fail(ExitConfigFailed, "can't load detections", "err", err)
+			}
+		}
+
+		var errs []ScanError
+		targets, errs = autoDetect(ctx, s.runner, targets, opts.profiles, detections, cfg.Concurrency)
+		s.errs = append(s.errs, errs...)
+
+		if err := writeDetections(path, detections); err != nil {
+			slog.Warn("can't save detections", "path", path, "err", err)
+		}
+	}
+
+	if cfg.CacheFile != "" && !cfg.UseCache {
+		if err := writeTargetCache(cfg.CacheFile, s.vc, targets); err != nil {
+			slog.Error("can't write target cache", "path", cfg.CacheFile, "err", err)
+		}
+	}
+
+	// a stable order keeps the scan order the same from run to run
+	sortTargets(targets)
+
+	// the ESXi hosts and cached targets get --profile too
+	if opts.profile != "" {
+		for i := range targets {
+			targets[i].Profile = opts.profile
+		}
+	}
+
+	// targets with several profiles are scanned once per profile
+	targets = splitProfiles(targets)
+
+	if cfg.MaxTargets > 0 {
+		var dropped int
+		targets, dropped = capTargets(targets, cfg.MaxTargets)
+		if dropped > 0 {
+			slog.Warn("capped targets", "max-targets", cfg.MaxTargets, "dropped", dropped)
+		}
+	}
+
+	// set up InSpec reporter
+	formats := reporterFormats(cfg.Reporters)
+
+	// name the output files after the targets so they are unique and stable
+	// no matter which worker picks up the target, or which run
+	for i := range targets {
+		targets[i].Reporter = buildReporter(formats, filepath.Join(cfg.ReportDir, outputName(targets[i])))
+		targets[i].WaiverFiles = waiverFilesFor(cfg.WaiverFiles, cfg.Waivers, targets[i])
+	}
+
+	return targets, nil
+}
+
+// Run scans targets, as Discover returned them, and writes the reports.
+// With InventoryCSV, CheckConnectivity, Plan, DryRun or ValidateProfiles
+// set it does that instead.
+func (s *Scanner) Run(ctx context.Context, targets []TargetConfig) (Report, error) {
+	cfg := s.cfg
+
+	switch {
+	case cfg.ValidateProfiles:
+		results := checkProfiles(ctx, s.runner, distinctProfiles(s.profileList, cfg.ProfileMap))
+		printProfileChecks(results)
+
+		var broken int
+		for _, r := range results {
+			if r.Err != nil {
+				broken++
+			}
+		}
+		slog.Info("checked profiles", "profiles", len(results), "broken", broken)
+
+		if broken > 0 {
+			return Report{}, &Error{Code: ExitConfigFailed, Msg: "broken profiles"}
+		}
+
+		return Report{Code: ExitOK}, nil
+	case cfg.InventoryCSV != "":
+		if err := writeInventoryCSV(cfg.InventoryCSV, targets); err != nil {
+			return Report{}, fail(ExitScanFailed, "can't write inventory", "path", cfg.InventoryCSV, "err", err)
+		}
+		slog.Info("wrote inventory", "path", cfg.InventoryCSV, "vms", len(targets))
+
+		return s.discovered(targets), nil
+	case cfg.CheckConnectivity:
+		// discovery already logged in to vCenter, so only the targets are
+		// left
+		results := checkConnectivity(ctx, targets, cfg.Concurrency)
+		printReachability(results)
+
+		var unreachable int
+		for _, r := range results {
+			if r.Err != nil {
+				unreachable++
+			}
+		}
+		slog.Info("checked connectivity", "targets", len(results), "unreachable", unreachable)
+
+		return s.discovered(targets), nil
+	case cfg.Plan:
+		if err := printPlanJSON(newPlan(s.vc.Host, s.runner, targets)); err != nil {
+			return Report{}, fail(ExitConfigFailed, "can't print plan", "err", err)
+		}
+
+		return s.discovered(targets), nil
+	case cfg.DryRun:
+		for _, t := range targets {
+			printPlan(s.runner, t)
+		}
+
+		return s.discovered(targets), nil
+	}
+
+	return s.scan(ctx, targets)
+}
+
+// discovered is the report of a run that only discovered targets.
+func (s *Scanner) discovered(targets []TargetConfig) Report {
+	r := Report{Code: ExitOK, VCenter: s.vc.Host, Targets: targets, Errors: s.errs}
+
+	if len(s.errs) > 0 {
+		printScanErrors(s.errs)
+		r.Code = ExitScanFailed
+	}
+
+	return r
+}
+
+// scan scans targets and writes their reports.
+func (s *Scanner) scan(ctx context.Context, targets []TargetConfig) (Report, error) {
+	cfg := s.cfg
+
+	// cleanup still has to reach vCenter after an interrupt
+	cleanupCtx := context.Background()
+
+	scanErrs := slices.Clone(s.errs)
+
+	// every target gets its own inputs, rendered from its vm
+	if len(s.inputs) > 0 {
+		for i, t := range targets {
+			if t.Skip != "" {
+				continue
+			}
+
+			path := filepath.Join(cfg.ReportDir, outputName(t)+".inputs.yml")
+			if err := writeInputs(path, s.inputs, t); err != nil {
+				return Report{}, fail(ExitConfigFailed, "can't write inputs", "target", t.Name, "err", err)
+			}
+			targets[i].InputFiles = []string{path}
+		}
+	}
+
+	// guest operations, snapshots, template clones and facts gathered in
+	// the guest need vCenter while scanning too
+	gatherFacts := cfg.Mode == ModeOffline && cfg.FactsDir == ""
+	if gatherFacts {
+		for i, t := range targets {
+			if t.Skip == "" && targetTransport(t) == transportVMware {
+				targets[i].Skip = skipHostFacts
+			}
+		}
+	}
+	var scanClient *vim25.Client
+	if cfg.Transport == TransportGuestOps || cfg.SnapshotBefore || cfg.ScanTemplates || gatherFacts {
+		c, err := s.newClient(ctx)
+		if err != nil {
+			return Report{}, fail(ExitConnectFailed, "can't connect to vCenter", "err", err)
+		}
+		if !cfg.SessionCache || cfg.SessionToken != "" {
+			defer c.Logout(cleanupCtx)
+		}
+
+		scanClient = c.Client
+	}
+
+	var r Runner = s.runner
+	switch {
+	case cfg.Mode == ModeOffline && gatherFacts:
+		r = offlineRunner{CLI: s.runner, facts: guestFactProvider{c: scanClient, command: cfg.FactCommand}}
+	case cfg.Mode == ModeOffline:
+		r = offlineRunner{CLI: s.runner, facts: dirFactProvider(cfg.FactsDir)}
+	case cfg.Transport == TransportGuestOps:
+		r = GuestOpsRunner{CLI: s.runner, Client: scanClient, Bin: cfg.GuestInspecBin, Timeout: cfg.ScanTimeout}
+	}
+	if cfg.MaxRetries > 0 {
+		r = retryRunner{Runner: r, maxRetries: cfg.MaxRetries, backoff: cfg.RetryBackoff}
+	}
+	if cfg.SnapshotBefore {
+		r = snapshotRunner{Runner: r, c: scanClient, cleanup: cfg.SnapshotCleanup, snapshots: &vmSnapshots{}}
+	}
+	if cfg.ScanTemplates {
+		wait := cfg.WaitForIP
+		if wait <= 0 {
+			wait = defaultCloneIPWait
+		}
+		r = templateRunner{Runner: r, c: scanClient, waitForIP: wait}
+	}
+	if cfg.SaveStderr {
+		r = stderrRunner{Runner: r, dir: cfg.ReportDir}
+	}
+	if s.metrics != nil {
+		r = metricsRunner{Runner: r, m: s.metrics}
+	}
+	if s.audit != nil {
+		r = auditRunner{Runner: r, a: s.audit}
+	}
+
+	// the reports of targets scanned by an earlier run are reused
+	toScan := targets
+	if cfg.Resume {
+		toScan = nil
+		var done int
+		for _, t := range targets {
+			if t.Skip == "" && reportComplete(t) {
+				done++
+				continue
+			}
+			toScan = append(toScan, t)
+		}
+
+		slog.Info("resuming, skipping targets with complete reports", "skipped", done)
+	}
+
+	// run inspec on the vms and the host
+	slog.Info("scanning", "targets", len(toScan))
+	failFast := FailOnNone
+	if cfg.FailFast {
+		failFast = cfg.FailOn
+	}
+	var limiter *adaptiveLimiter
+	if cfg.ConcurrencyAdaptive {
+		limiter = newAdaptiveLimiter(cfg.MinConcurrency, cfg.Concurrency, cfg.FailureWindow, cfg.FailureThreshold)
+	}
+	errs, durations := runScans(ctx, cfg.ShutdownGrace, r, toScan, cfg.Concurrency, cfg.WorkersPerHost, failFast, limiter)
+	scanErrs = append(scanErrs, errs...)
+
+	if cfg.Compress {
+		compressReports(toScan)
+	}
+
+	summaries := summarize(targets, durations)
+	printSummaries(summaries)
+
+	if cfg.CombinedReport != "" {
+		path := cfg.CombinedReport
+		if cfg.Compress && !strings.HasSuffix(path, gzipExt) {
+			path += gzipExt
+		}
+
+		names := map[string]string{}
+		var paths []string
+		var skipped []TargetReport
+		for _, t := range targets {
+			if t.Skip != "" {
+				skipped = append(skipped, TargetReport{Target: t.Name, Skip: t.Skip})
+				continue
+			}
+			names[outputName(t)] = t.Name
+			paths = append(paths, reportFile(t, "json"))
+		}
+
+		report, err := mergeReports(paths)
+		if err == nil {
+			for i := range report.Targets {
+				uuid := strings.TrimSuffix(filepath.Base(report.Targets[i].File), ".json")
+				report.Targets[i].Target = names[uuid]
+				report.Targets[i].DurationMs = durations[names[uuid]]
+			}
+			report.Targets = append(report.Targets, skipped...)
+			err = writeCombinedReport(path, report)
+		}
+		if err != nil {
+			slog.Error("can't write combined report", "path", path, "err", err)
+		}
+	}
+
+	// archive what was scanned, even after an interrupt
+	archiveReports(cleanupCtx, s.sink, s.vc.Host, s.started, targets, cfg.Compress)
+
+	js := newJSONSummary(s.vc.Host, s.vc.Version, targets, summaries)
+
+	if cfg.SummaryJSON {
+		if err := printJSONSummary(js); err != nil {
+			slog.Error("can't print JSON summary", "err", err)
+		}
+	}
+
+	if cfg.HTMLReport != "" {
+		if err := writeHTMLReport(cfg.HTMLReport, js, targets, summaries); err != nil {
+			slog.Error("can't write HTML report", "path", cfg.HTMLReport, "err", err)
+		}
+	}
+
+	if cfg.JUnit != "" {
+		if err := writeJUnitReport(cfg.JUnit, targets); err != nil {
+			slog.Error("can't write JUnit report", "path", cfg.JUnit, "err", err)
+		}
+	}
+
+	if cfg.SARIF != "" {
+		if err := writeSARIFReport(cfg.SARIF, targets); err != nil {
+			slog.Error("can't write SARIF report", "path", cfg.SARIF, "err", err)
+		}
+	}
+
+	if len(scanErrs) > 0 {
+		printScanErrors(scanErrs)
+	}
+
+	if ctx.Err() != nil {
+		slog.Warn("interrupted, results are incomplete")
+	}
+
+	code := exitCode(ctx.Err() != nil, scanErrs, summaries, cfg.FailOn)
+
+	for _, e := range scanErrs {
+		s.audit.Log(auditEvent{Event: auditError, Target: e.Target, Stage: e.Stage, Error: e.Err.Error()})
+	}
+	s.audit.Log(auditEvent{Event: auditRunDone, VCenter: s.vc.Host, ExitCode: &code, Totals: &js.Totals})
+
+	// only a run that scanned everything makes old reports expendable
+	if cfg.ReportRetention != "" && (code == ExitOK || code == ExitNonCompliant) {
+		base := s.reportBase
+		if base == "" {
+			base = "."
+		}
+		if err := pruneRunDirs(base, s.keep, time.Now(), filepath.Base(cfg.ReportDir)); err != nil {
+			slog.Error("can't prune report directories", "dir", base, "err", err)
+		}
+	}
+
+	if cfg.WebhookURL != "" {
+		wh := newWebhookSummary(s.vc.Host, code, js.Totals, targets)
+		if err := postWebhook(cleanupCtx, cfg.WebhookURL, cfg.WebhookFormat, wh); err != nil {
+			slog.Error("can't post to webhook", "err", err)
+		}
+	}
+
+	return Report{Code: code, VCenter: s.vc.Host, Targets: targets, Summaries: summaries, Errors: scanErrs}, nil
+}
+
+// exitCode returns the exit code of a run.
+func exitCode(interrupted bool, scanErrs []ScanError, summaries []Summary, failOn string) int {
+	if interrupted {
+		return ExitInterrupted
+	}
+
+	if len(scanErrs) > 0 {
+		return ExitScanFailed
+	}
+
+	if shouldFail(summaries, failOn) {
+		return ExitNonCompliant
+	}
+
+	return ExitOK
+}
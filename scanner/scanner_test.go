@@ -0,0 +1,603 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestTargetConfigKeyFiles(t *testing.T) {
+	vm := inventory.VMInfo{Name: "web-1", InventoryPath: "/dc/vm/web-1", IP: "10.0.0.5", GuestFamily: "linuxGuest"}
+	cred := Credential{User: "root", Password: "secret", SSHKeyPath: "/keys/id_ed25519", SudoPassword: "sudo-secret"}
+
+	b, err := json.Marshal(newTarget(discoverOptions{logLevel: "info"}, vm, "linux-baseline", cred))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"target":        "ssh://10.0.0.5",
+		"user":          "root",
+		"key_files":     []interface{}{"/keys/id_ed25519"},
+		"sudo_password": "sudo-secret",
+		"insecure":      true,
+		"log-level":     "info",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("json config = %s, want %v", b, want)
+	}
+}
+
+func TestTargetConfigRoundTrip(t *testing.T) {
+	in := TargetConfig{Target: "ssh://10.0.0.5", User: "root", Password: "secret"}
+
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out TargetConfig
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Target != in.Target {
+		t.Errorf("target = %q, want %q", out.Target, in.Target)
+	}
+	if out.Password != in.Password {
+		t.Errorf("password = %q, want %q", out.Password, in.Password)
+	}
+}
+
+// targetJSON returns the json config inspec is handed for t.
+func targetJSON(t *testing.T, tc TargetConfig) map[string]interface{} {
+	t.Helper()
+
+	b, err := json.Marshal(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestTargetConfigBastion(t *testing.T) {
+	bastions, err := compileBastions([]Bastion{{CIDR: "10.1.0.0/16", Host: "jump.example.com", User: "jump", Port: 2222}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := discoverOptions{bastions: bastions}
+	cred := Credential{User: "root", Password: "secret"}
+
+	behind := targetJSON(t, newTarget(opts, inventory.VMInfo{IP: "10.1.0.5", GuestFamily: "linuxGuest"}, "linux-baseline", cred))
+	want := map[string]interface{}{"bastion_host": "jump.example.com", "bastion_user": "jump", "bastion_port": 2222.0}
+	for k, v := range want {
+		if behind[k] != v {
+			t.Errorf("behind the bastion: %s = %v, want %v", k, behind[k], v)
+		}
+	}
+
+	outside := targetJSON(t, newTarget(opts, inventory.VMInfo{IP: "10.2.0.5", GuestFamily: "linuxGuest"}, "linux-baseline", cred))
+	windows := targetJSON(t, newTarget(opts, inventory.VMInfo{IP: "10.1.0.6", GuestFamily: "windowsGuest"}, "windows-baseline", cred))
+	for k := range want {
+		if v, ok := outside[k]; ok {
+			t.Errorf("outside the bastion's subnet: %s = %v, want none", k, v)
+		}
+		if v, ok := windows[k]; ok {
+			t.Errorf("over winrm: %s = %v, want none", k, v)
+		}
+	}
+}
+
+func TestTargetConfigSudo(t *testing.T) {
+	linux := inventory.VMInfo{IP: "10.0.0.5", GuestFamily: "linuxGuest"}
+	sudo := []string{"sudo", "sudo_password", "sudo_options"}
+
+	tests := []struct {
+		name string
+		opts discoverOptions
+		vm   inventory.VMInfo
+		cred Credential
+		want map[string]interface{}
+	}{
+		{
+			name: "no sudo",
+			vm:   linux,
+			cred: Credential{User: "deploy", Password: "secret"},
+		},
+		{
+			name: "sudo",
+			opts: discoverOptions{sudo: true, sudoPassword: "flag-secret", sudoOptions: "-H"},
+			vm:   linux,
+			cred: Credential{User: "deploy", Password: "secret"},
+			want: map[string]interface{}{"sudo": true, "sudo_password": "flag-secret", "sudo_options": "-H"},
+		},
+		{
+			name: "sudo with a key",
+			opts: discoverOptions{sudo: true, sudoPassword: "flag-secret"},
+			vm:   linux,
+			cred: Credential{User: "deploy", SSHKeyPath: "/keys/id_ed25519", SudoPassword: "cred-secret"},
+			want: map[string]interface{}{"sudo": true, "sudo_password": "cred-secret"},
+		},
+		{
+			name: "sudo over winrm",
+			opts: discoverOptions{sudo: true, sudoPassword: "flag-secret"},
+			vm:   inventory.VMInfo{IP: "10.0.0.6", GuestFamily: "windowsGuest"},
+			cred: Credential{User: "Administrator", Password: "secret"},
+		},
+	}
+	for _, tt := range tests {
+		got := targetJSON(t, newTarget(tt.opts, tt.vm, "baseline", tt.cred))
+		for _, k := range sudo {
+			if got[k] != tt.want[k] {
+				t.Errorf("%s: %s = %v, want %v", tt.name, k, got[k], tt.want[k])
+			}
+		}
+	}
+}
+
+func TestTargetConfigPort(t *testing.T) {
+	rules, err := compilePorts([]PortRule{
+		{CIDR: "10.1.0.0/16", Transport: transportSSH, Port: 2222},
+		{CIDR: "10.2.0.0/16", Port: 5986},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := Credential{User: "root", Password: "secret"}
+
+	tests := []struct {
+		name string
+		port int
+		vm   inventory.VMInfo
+		want interface{}
+	}{
+		{"default", 0, inventory.VMInfo{IP: "10.0.0.5", GuestFamily: "linuxGuest"}, nil},
+		{"--port", 22022, inventory.VMInfo{IP: "10.0.0.5", GuestFamily: "linuxGuest"}, 22022.0},
+		{"ssh rule", 22022, inventory.VMInfo{IP: "10.1.0.5", GuestFamily: "linuxGuest"}, 2222.0},
+		{"ssh rule over winrm", 0, inventory.VMInfo{IP: "10.1.0.6", GuestFamily: "windowsGuest"}, nil},
+		{"any transport rule", 0, inventory.VMInfo{IP: "10.2.0.5", GuestFamily: "windowsGuest"}, 5986.0},
+	}
+	for _, tt := range tests {
+		opts := discoverOptions{ports: rules, port: tt.port}
+
+		got := targetJSON(t, newTarget(opts, tt.vm, "baseline", cred))
+		if got["port"] != tt.want {
+			t.Errorf("%s: port = %v, want %v", tt.name, got["port"], tt.want)
+		}
+	}
+}
+
+// fakeRunner records the targets it is asked to scan instead of running
+// inspec.
+type fakeRunner struct {
+	mu      sync.Mutex
+	scanned []TargetConfig
+}
+
+func (r *fakeRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scanned = append(r.scanned, t)
+
+	return Result{Target: t.Name, Profile: profile}, nil
+}
+
+// setGuestIP makes the simulator report ip as the guest IP of vm.
+func setGuestIP(ctx context.Context, vm *object.VirtualMachine, ip string) error {
+	spec := types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{&types.OptionValue{Key: "SET.guest.ipAddress", Value: ip}},
+	}
+
+	task, err := vm.Reconfigure(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	return task.Wait(ctx)
+}
+
+func TestDiscoverAndScanSimulator(t *testing.T) {
+	// a standalone host and a cluster of three, with two vms each; one
+	// cluster host is left without vms
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := model.Run(func(ctx context.Context, c *vim25.Client) error {
+		f := find.NewFinder(c, true)
+
+		hosts, err := f.HostSystemList(ctx, "*")
+		if err != nil {
+			return err
+		}
+
+		vms, err := f.VirtualMachineList(ctx, "*")
+		if err != nil {
+			return err
+		}
+		if len(vms) < 2 {
+			return fmt.Errorf("simulator has %d vms, want at least 2", len(vms))
+		}
+
+		// every vm gets an IP, and the last is powered off, so it isn't
+		// scanned
+		wantIPs := map[string]string{}
+		for i, vm := range vms {
+			ip := fmt.Sprintf("10.0.0.%d", i+1)
+			if err := setGuestIP(ctx, vm, ip); err != nil {
+				return err
+			}
+			wantIPs[vm.Name()] = ip
+		}
+
+		off := vms[len(vms)-1]
+		task, err := off.PowerOff(ctx)
+		if err != nil {
+			return err
+		}
+		if err := task.Wait(ctx); err != nil {
+			return err
+		}
+		delete(wantIPs, off.Name())
+
+		opts := discoverOptions{
+			creds:            &CredentialStore{Default: &Credential{User: "root", Password: "secret"}},
+			profile:          "linux-baseline",
+			powerState:       string(types.VirtualMachinePowerStatePoweredOn),
+			discoveryWorkers: 2,
+		}
+
+		targets, scanErrs, err := discoverTargets(ctx, c, opts)
+		if err != nil {
+			return err
+		}
+		if len(scanErrs) > 0 {
+			t.Errorf("discovery errors: %v", scanErrs)
+		}
+
+		r := &fakeRunner{}
+		if errs, _ := runScans(ctx, 0, r, targets, 2, 0, FailOnNone, nil); len(errs) > 0 {
+			t.Errorf("scan errors: %v", errs)
+		}
+
+		if want := len(hosts) + len(wantIPs); len(r.scanned) != want {
+			t.Errorf("scanned %d targets, want %d hosts and %d powered on vms", len(r.scanned), len(hosts), len(wantIPs))
+		}
+
+		gotIPs := map[string]string{}
+		for _, tc := range r.scanned {
+			switch targetTransport(tc) {
+			case transportVMware:
+				if tc.Profile != vsphereProfile {
+					t.Errorf("host %s: profile = %q, want %q", tc.Name, tc.Profile, vsphereProfile)
+				}
+			case transportSSH:
+				gotIPs[tc.VM.Name] = targetHost(tc)
+				if tc.User != "root" || tc.Password != "secret" {
+					t.Errorf("vm %s: login = %s/%s, want root/secret", tc.Name, tc.User, tc.Password)
+				}
+				if tc.Profile != "linux-baseline" {
+					t.Errorf("vm %s: profile = %q, want linux-baseline", tc.Name, tc.Profile)
+				}
+				if tc.VM.PowerState != types.VirtualMachinePowerStatePoweredOn {
+					t.Errorf("vm %s: power state = %s, want poweredOn", tc.Name, tc.VM.PowerState)
+				}
+			default:
+				t.Errorf("target %s: unexpected target %q", tc.Name, tc.Target)
+			}
+		}
+
+		if !reflect.DeepEqual(gotIPs, wantIPs) {
+			t.Errorf("vm IPs = %v, want %v", gotIPs, wantIPs)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverHostGuestIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		waitForIP time.Duration
+		ipAfter   time.Duration
+		want      string
+	}{
+		{name: "no IP", want: ""},
+		{name: "no IP by the deadline", waitForIP: 100 * time.Millisecond, want: ""},
+		{name: "IP while waiting", waitForIP: 10 * time.Second, ipAfter: 200 * time.Millisecond, want: "10.0.0.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a single host with a single vm
+			model := simulator.VPX()
+			model.Cluster = 0
+			model.Machine = 1
+			defer model.Remove()
+			if err := model.Create(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := model.Run(func(ctx context.Context, c *vim25.Client) error {
+				hosts, err := inventory.ListHosts(ctx, c)
+				if err != nil {
+					return err
+				}
+				if len(hosts) != 1 {
+					return fmt.Errorf("simulator has %d hosts, want 1", len(hosts))
+				}
+
+				vms, err := find.NewFinder(c, true).VirtualMachineList(ctx, "*")
+				if err != nil {
+					return err
+				}
+				if err := setGuestIP(ctx, vms[0], ""); err != nil {
+					return err
+				}
+
+				if tt.want != "" {
+					go func() {
+						time.Sleep(tt.ipAfter)
+						if err := setGuestIP(ctx, vms[0], tt.want); err != nil {
+							t.Error(err)
+						}
+					}()
+				}
+
+				opts := discoverOptions{
+					creds:      &CredentialStore{Default: &Credential{User: "root", Password: "secret"}},
+					profile:    "linux-baseline",
+					powerState: string(types.VirtualMachinePowerStatePoweredOn),
+					waitForIP:  tt.waitForIP,
+				}
+
+				targets, scanErrs := discoverHost(ctx, c, opts, inventoryScope{}, hosts[0])
+				if len(scanErrs) > 0 {
+					t.Errorf("discovery errors: %v", scanErrs)
+				}
+
+				var got string
+				var n int
+				for _, tc := range targets {
+					if targetTransport(tc) == transportSSH {
+						got = targetHost(tc)
+						n++
+					}
+				}
+
+				switch {
+				case tt.want == "" && n > 0:
+					t.Errorf("vm without IP became a target on %q", got)
+				case tt.want != "" && got != tt.want:
+					t.Errorf("vm target IP = %q, want %q", got, tt.want)
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBuildReporter(t *testing.T) {
+	got := buildReporter([]string{"cli", "json", "json-min", "html"}, "reports/vm-1")
+
+	want := map[string]map[string]interface{}{
+		"cli":      {"stdout": true},
+		"json":     {"file": "reports/vm-1.json", "stdout": false},
+		"json-min": {"file": "reports/vm-1-min.json", "stdout": false},
+		"html":     {"file": "reports/vm-1.html", "stdout": false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildReporter = %v, want %v", got, want)
+	}
+}
+
+func TestReporterFormats(t *testing.T) {
+	tests := []struct {
+		formats []string
+		want    []string
+	}{
+		{nil, []string{"cli", "json", "json-min"}},
+		{[]string{"html"}, []string{"html", "json", "json-min"}},
+		{[]string{"json", "cli"}, []string{"json", "cli", "json-min"}},
+	}
+
+	for _, tt := range tests {
+		if got := reporterFormats(tt.formats); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("reporterFormats(%q) = %q, want %q", tt.formats, got, tt.want)
+		}
+	}
+}
+
+// targetNames returns the names of targets in order.
+func targetNames(targets []TargetConfig) []string {
+	var names []string
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+
+	return names
+}
+
+func TestSortTargets(t *testing.T) {
+	vm := func(host, name, path string) TargetConfig {
+		return TargetConfig{Name: path, Target: "ssh://10.0.0.1", VM: inventory.VMInfo{Host: host, Name: name}}
+	}
+	host := func(name string) TargetConfig {
+		return TargetConfig{Name: "/dc/host/" + name, Target: "vmware://" + name, VM: inventory.VMInfo{Host: name, Name: name}}
+	}
+
+	want := []TargetConfig{
+		host("esx-a"),
+		vm("esx-a", "app", "/dc/vm/app"),
+		vm("esx-a", "web", "/dc/vm/prod/web"),
+		vm("esx-a", "web", "/dc/vm/test/web"),
+		host("esx-b"),
+		vm("esx-b", "db", "/dc/vm/db"),
+	}
+
+	// every rotation of the targets, reversed, sorts the same
+	for i := range want {
+		got := append(slices.Clone(want[i:]), want[:i]...)
+		slices.Reverse(got)
+		sortTargets(got)
+
+		if !reflect.DeepEqual(targetNames(got), targetNames(want)) {
+			t.Errorf("rotation %d sorted to %q, want %q", i, targetNames(got), targetNames(want))
+		}
+	}
+}
+
+func TestSortTargetsSimulator(t *testing.T) {
+	model := simulator.VPX()
+	model.Machine = 4
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := model.Run(func(ctx context.Context, c *vim25.Client) error {
+		vms, err := find.NewFinder(c, true).VirtualMachineList(ctx, "*")
+		if err != nil {
+			return err
+		}
+		for i, vm := range vms {
+			if err := setGuestIP(ctx, vm, fmt.Sprintf("10.0.0.%d", i+1)); err != nil {
+				return err
+			}
+		}
+
+		opts := discoverOptions{
+			creds:            &CredentialStore{Default: &Credential{User: "root", Password: "secret"}},
+			profile:          "linux-baseline",
+			powerState:       string(types.VirtualMachinePowerStatePoweredOn),
+			discoveryWorkers: 4,
+		}
+
+		// hosts are listed concurrently, so only sorting makes the order
+		// stable
+		var first []string
+		for run := 0; run < 5; run++ {
+			targets, _, err := discoverTargets(ctx, c, opts)
+			if err != nil {
+				return err
+			}
+			sortTargets(targets)
+
+			for i := 1; i < len(targets); i++ {
+				if targets[i-1].VM.Host > targets[i].VM.Host {
+					t.Errorf("run %d: %s sorted before %s", run, targets[i-1].Name, targets[i].Name)
+				}
+			}
+
+			names := targetNames(targets)
+			if run == 0 {
+				first = names
+			} else if !reflect.DeepEqual(names, first) {
+				t.Errorf("run %d: order %q, want %q as in the first run", run, names, first)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// benchmarkDiscovery discovers the vms of a simulator with many hosts,
+// listing workers hosts at once, over a client of its own that tune sets
+// up.
+func benchmarkDiscovery(b *testing.B, workers int, tune func(*soap.Client)) {
+	model := simulator.VPX()
+	model.Host = 20
+	model.Machine = 10
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		b.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	ctx := context.Background()
+	sc := soap.NewClient(s.URL, true)
+	tune(sc)
+
+	c, err := vim25.NewClient(ctx, sc)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := session.NewManager(c).Login(ctx, s.URL.User); err != nil {
+		b.Fatal(err)
+	}
+
+	opts := discoverOptions{inventoryOnly: true, powerState: "all", discoveryWorkers: workers}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := discoverTargets(ctx, c, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// tuneTransport sets up a transport as the --max-idle-conns and --keepalive
+// defaults do.
+var tuneTransport = (&Scanner{cfg: Config{MaxIdleConns: 16, Keepalive: 90 * time.Second}}).tuneTransport
+
+// BenchmarkDiscoveryDefaultTransport discovers over the transport soap sets
+// up, which keeps few idle connections.
+func BenchmarkDiscoveryDefaultTransport(b *testing.B) {
+	benchmarkDiscovery(b, 8, func(*soap.Client) {})
+}
+
+// BenchmarkDiscoveryTunedTransport discovers over the transport as
+// --max-idle-conns and --keepalive tune it.
+func BenchmarkDiscoveryTunedTransport(b *testing.B) {
+	benchmarkDiscovery(b, 8, tuneTransport)
+}
+
+// BenchmarkDiscoverySerial lists the vms of one host at a time.
+func BenchmarkDiscoverySerial(b *testing.B) {
+	benchmarkDiscovery(b, 1, tuneTransport)
+}
+
+// BenchmarkDiscoveryParallel lists the vms of 8 hosts at once.
+func BenchmarkDiscoveryParallel(b *testing.B) {
+	benchmarkDiscovery(b, 8, tuneTransport)
+}
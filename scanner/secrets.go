@@ -0,0 +1,161 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// SecretProvider fetches guest credentials from a secret store, for the
+// VM or ESXi host called name at addr, and for a VM its guest family. A
+// provider that has none for it returns an error wrapping errNoCredential.
+type SecretProvider interface {
+	Get(name, addr, family string) (Credential, error)
+}
+
+// errNoCredential means a SecretProvider has no credential for a name.
+var errNoCredential = errors.New("no credential")
+
+// ChainProvider tries its providers in order, and the first that has a
+// credential wins. Any other error stops the lookup, so a secret store
+// that's down doesn't silently fall through to a weaker credential.
+type ChainProvider []SecretProvider
+
+func (c ChainProvider) Get(name, addr, family string) (Credential, error) {
+	for _, p := range c {
+		cred, err := p.Get(name, addr, family)
+		if !errors.Is(err, errNoCredential) {
+			return cred, err
+		}
+	}
+
+	return Credential{}, fmt.Errorf("%s: %w", name, errNoCredential)
+}
+
+// storeProvider looks credentials up in a CredentialStore.
+type storeProvider struct {
+	s *CredentialStore
+}
+
+func (p storeProvider) Get(name, addr, family string) (Credential, error) {
+	cred, ok := p.s.lookup(name, addr, family)
+	if !ok {
+		return Credential{}, fmt.Errorf("%s: %w", name, errNoCredential)
+	}
+
+	return cred, nil
+}
+
+// CredentialSource is an entry of the credential-chain of a --config file:
+// a --credentials file, credentials given inline, or a Vault path.
+type CredentialSource struct {
+	File        string           `yaml:"file"`
+	Credentials *CredentialStore `yaml:"credentials"`
+	VaultAddr   string           `yaml:"vault-addr"`
+	VaultPath   string           `yaml:"vault-path"`
+}
+
+// newChainProvider returns the chain of the providers of sources, in
+// order.
+func newChainProvider(sources []CredentialSource) (ChainProvider, error) {
+	var c ChainProvider
+	for i, src := range sources {
+		var n int
+		for _, set := range []bool{src.File != "", src.Credentials != nil, src.VaultPath != ""} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("credential source %d needs exactly one of file, credentials or vault-path", i+1)
+		}
+
+		switch {
+		case src.File != "":
+			s, err := LoadCredentialStore(src.File)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, storeProvider{s})
+		case src.Credentials != nil:
+			c = append(c, storeProvider{src.Credentials})
+		default:
+			p, err := newVaultProvider(src.VaultAddr, src.VaultPath)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, p)
+		}
+	}
+
+	return c, nil
+}
+
+// vaultProvider reads guest credentials from HashiCorp Vault. The secret
+// path is a template where {name} is replaced by the key, e.g.
+// secret/data/inspec/{name}. Both KV v1 and v2 secrets are understood, with
+// the same fields as a --credentials entry: user, password, ssh_key_path
+// and sudo_password.
+type vaultProvider struct {
+	c    *vault.Client
+	path string
+}
+
+// newVaultProvider returns a provider for the Vault server at addr. The
+// token is read from VAULT_TOKEN, like the vault command does.
+func newVaultProvider(addr string, path string) (*vaultProvider, error) {
+	if !strings.Contains(path, "{name}") {
+		return nil, fmt.Errorf("vault path %q has no {name}", path)
+	}
+
+	conf := vault.DefaultConfig()
+	if addr != "" {
+		conf.Address = addr
+	}
+
+	c, err := vault.NewClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vaultProvider{c: c, path: path}, nil
+}
+
+func (p *vaultProvider) Get(name, addr, family string) (Credential, error) {
+	path := strings.ReplaceAll(p.path, "{name}", name)
+
+	s, err := p.c.Logical().Read(path)
+	if err != nil {
+		return Credential{}, fmt.Errorf("vault %s: %s", path, err)
+	}
+	if s == nil || s.Data == nil {
+		return Credential{}, fmt.Errorf("vault %s: %w", path, errNoCredential)
+	}
+
+	// KV v2 nests the secret under data
+	data := s.Data
+	if d, ok := data["data"].(map[string]interface{}); ok {
+		data = d
+	}
+
+	field := func(name string) string {
+		v, _ := data[name].(string)
+		return v
+	}
+
+	cred := Credential{
+		User:         field("user"),
+		Password:     field("password"),
+		SSHKeyPath:   field("ssh_key_path"),
+		SudoPassword: field("sudo_password"),
+		Source:       "vault " + path,
+	}
+
+	if cred.User == "" {
+		return Credential{}, fmt.Errorf("vault %s: no user", path)
+	}
+
+	return cred, nil
+}
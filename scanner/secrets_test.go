@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gpeers/vmware-poc/inventory"
+)
+
+// fakeProvider is a SecretProvider with a credential for the names in
+// creds, failing with err for any other if err is set.
+type fakeProvider struct {
+	creds map[string]Credential
+	err   error
+}
+
+func (p fakeProvider) Get(name, addr, family string) (Credential, error) {
+	if cred, ok := p.creds[name]; ok {
+		return cred, nil
+	}
+	if p.err != nil {
+		return Credential{}, p.err
+	}
+
+	return Credential{}, fmt.Errorf("%s: %w", name, errNoCredential)
+}
+
+func TestChainProvider(t *testing.T) {
+	down := errors.New("vault is down")
+	chain := ChainProvider{
+		fakeProvider{creds: map[string]Credential{"web-1": {User: "first"}}},
+		fakeProvider{creds: map[string]Credential{"web-1": {User: "second"}, "web-2": {User: "second"}}},
+		fakeProvider{creds: map[string]Credential{"db-1": {User: "third"}}, err: down},
+		fakeProvider{creds: map[string]Credential{"app-1": {User: "fourth"}}},
+	}
+
+	tests := []struct {
+		name string
+		user string
+		err  error
+	}{
+		{"web-1", "first", nil},
+		{"web-2", "second", nil},
+		{"db-1", "third", nil},
+		{"app-1", "", down},
+	}
+	for _, tt := range tests {
+		cred, err := chain.Get(tt.name, "", "")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Get(%s): err = %v, want %v", tt.name, err, tt.err)
+		}
+		if cred.User != tt.user {
+			t.Errorf("Get(%s): user = %q, want %q", tt.name, cred.User, tt.user)
+		}
+	}
+
+	_, err := ChainProvider{fakeProvider{}}.Get("web-1", "", "")
+	if !errors.Is(err, errNoCredential) {
+		t.Errorf("Get from an empty chain: err = %v, want errNoCredential", err)
+	}
+}
+
+func TestCredentialFor(t *testing.T) {
+	down := errors.New("vault is down")
+
+	tests := []struct {
+		name    string
+		secrets SecretProvider
+		ok      bool
+		err     error
+	}{
+		{"found", fakeProvider{creds: map[string]Credential{"web-1": {User: "root"}}}, true, nil},
+		{"none", ChainProvider{fakeProvider{}, fakeProvider{}}, false, errNoCredential},
+		{"down", fakeProvider{err: down}, false, down},
+	}
+	for _, tt := range tests {
+		cred, ok, err := credentialFor(discoverOptions{secrets: tt.secrets}, inventory.VMInfo{Name: "web-1"})
+		if ok != tt.ok || !errors.Is(err, tt.err) {
+			t.Errorf("%s: credentialFor = %v, %v, want %v, %v", tt.name, ok, err, tt.ok, tt.err)
+		}
+		if ok && cred.User != "root" {
+			t.Errorf("%s: user = %q, want root", tt.name, cred.User)
+		}
+	}
+}
+
+func TestHostCredentialFor(t *testing.T) {
+	esx := inventory.HostInfo{Name: "esx-1"}
+	down := errors.New("vault is down")
+	vault := fakeProvider{creds: map[string]Credential{"esx-1": {User: "vault"}}}
+	none := ChainProvider{fakeProvider{}}
+	file := &CredentialStore{Default: &Credential{User: "file"}}
+
+	tests := []struct {
+		name     string
+		opts     discoverOptions
+		password string
+		user     string
+		err      error
+	}{
+		{"provider", discoverOptions{secrets: vault, creds: file}, "secret", "vault", nil},
+		{"provider has none, file", discoverOptions{secrets: none, creds: file}, "secret", "file", nil},
+		{"provider has none, flags", discoverOptions{secrets: none}, "secret", "root", nil},
+		{"provider has none, nor flags", discoverOptions{secrets: none}, "", "", errNoCredential},
+		{"provider down", discoverOptions{secrets: fakeProvider{err: down}, creds: file}, "secret", "", down},
+		{"nothing", discoverOptions{}, "", "", nil},
+		{"hosts file", discoverOptions{secrets: vault, hostLogin: &Credential{User: "hosts-file"}}, "", "hosts-file", nil},
+	}
+	for _, tt := range tests {
+		tt.opts.esxiUser, tt.opts.esxiPassword = "root", tt.password
+
+		cred, ok, err := hostCredentialFor(tt.opts, esx)
+		if !errors.Is(err, tt.err) || ok != (tt.user != "") || cred.User != tt.user {
+			t.Errorf("%s: hostCredentialFor = %q, %v, %v, want %q, %v", tt.name, cred.User, ok, err, tt.user, tt.err)
+		}
+	}
+}
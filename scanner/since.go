@@ -0,0 +1,34 @@
+package scanner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+)
+
+// parseSince parses a --since value: a duration before now, e.g. 24h, or
+// an RFC 3339 timestamp or date.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a timestamp", s)
+}
+
+// changedSince reports whether vm was powered on (runtime.bootTime) or
+// reconfigured (config.modified) at or after since. A vm that reports
+// neither counts as unchanged.
+func changedSince(vm inventory.VMInfo, since time.Time) bool {
+	return !vm.BootTime.IsZero() && !vm.BootTime.Before(since) ||
+		!vm.Modified.IsZero() && !vm.Modified.Before(since)
+}
@@ -0,0 +1,117 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ResultSink stores a finished scan report under key.
+type ResultSink interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// localSink is the default sink. InSpec has already written the reports
+// to local files, so there is nothing left to do.
+type localSink struct{}
+
+func (localSink) Put(ctx context.Context, key string, data []byte) error {
+	return nil
+}
+
+// s3Sink uploads reports to an S3 bucket, below prefix. Credentials and
+// region come from the usual AWS environment, config and profile.
+type s3Sink struct {
+	c      *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Sink(ctx context.Context, bucket, prefix string) (*s3Sink, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Sink{
+		c:      s3.NewFromConfig(cfg),
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (s *s3Sink) Put(ctx context.Context, key string, data []byte) error {
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(path.Join(s.prefix, key)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+
+	// gzipped reports are still json, just compressed
+	if strings.HasSuffix(key, gzipExt) {
+		in.ContentEncoding = aws.String("gzip")
+	}
+
+	_, err := s.c.PutObject(ctx, in)
+
+	return err
+}
+
+// sinkAttempts is how many times an upload is tried before giving up.
+const sinkAttempts = 3
+
+// archiveReports puts the json report of every scanned target into sink
+// as <vcenter>/<timestamp>/<outputName>.json, named like the local report
+// so vms of the same name don't overwrite each other, or gzipped as
+// <outputName>.json.gz if compress is set. Failed uploads are retried and then logged; they never
+// fail the run.
+func archiveReports(ctx context.Context, sink ResultSink, vcenter string, started time.Time, targets []TargetConfig, compress bool) {
+	dir := path.Join(vcenter, started.UTC().Format("20060102T150405Z"))
+
+	for _, t := range targets {
+		if t.Skip != "" {
+			continue
+		}
+
+		data, err := readReportFile(reportFile(t, "json"))
+		if err != nil {
+			slog.Warn("no report to archive", "target", t.Name, "err", err)
+			continue
+		}
+
+		key := path.Join(dir, outputName(t)+".json")
+		if compress {
+			if data, err = gzipBytes(data); err != nil {
+				slog.Error("can't compress report", "target", t.Name, "err", err)
+				continue
+			}
+			key += gzipExt
+		}
+
+		wait := time.Second
+		for attempt := 1; ; attempt++ {
+			err = sink.Put(ctx, key, data)
+			if err == nil || attempt >= sinkAttempts || ctx.Err() != nil {
+				break
+			}
+
+			slog.Info("retrying report upload", "target", t.Name, "attempt", attempt, "wait", wait, "err", err)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+			wait *= 2
+		}
+		if err != nil {
+			slog.Error("can't archive report", "target", t.Name, "key", key, "err", err)
+		}
+	}
+}
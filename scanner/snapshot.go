@@ -0,0 +1,171 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// snapshotPrefix starts the names of the snapshots taken before scans, so
+// they are easy to recognize and clean up by hand.
+const snapshotPrefix = "vmware-poc-"
+
+// errSnapshot marks scans that didn't run because the vm couldn't be
+// snapshotted.
+var errSnapshot = errors.New("can't snapshot vm")
+
+// snapshotRunner snapshots every vm before it is scanned, and removes the
+// snapshot again afterwards if cleanup is set. A vm that can't be
+// snapshotted isn't scanned. The ESXi hosts are scanned as they are.
+type snapshotRunner struct {
+	Runner
+	c         *vim25.Client
+	cleanup   bool
+	snapshots *vmSnapshots
+}
+
+func (r snapshotRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	if targetTransport(t) == transportVMware {
+		return r.Runner.Run(ctx, t, profile)
+	}
+
+	vm, err := inventory.FindVM(ctx, r.c, t.VM.UUID)
+	if err != nil {
+		return Result{Target: t.Name, Profile: profile}, fmt.Errorf("%w: %s", errSnapshot, err)
+	}
+
+	snap, err := r.snapshots.acquire(ctx, vm)
+	defer r.snapshots.release(vm, snap, r.cleanup)
+	if err != nil {
+		return Result{Target: t.Name, Profile: profile}, fmt.Errorf("%w: %s", errSnapshot, err)
+	}
+
+	return r.Runner.Run(ctx, t, profile)
+}
+
+// vmSnapshots are the snapshots of the vms being scanned, by vm. The
+// targets that scan a vm with several profiles can run at once, and share
+// a single snapshot: it is taken by the first of them, and removed after
+// the last, so the vm's tasks never overlap.
+type vmSnapshots struct {
+	mu   sync.Mutex
+	byVM map[types.ManagedObjectReference]*vmSnapshot
+}
+
+// vmSnapshot is the snapshot of a vm and the scans using it.
+type vmSnapshot struct {
+	// taken is closed once the snapshot is taken, or failed to be with err
+	taken chan struct{}
+	// removed is closed once the snapshot is done with, after the last scan
+	removed chan struct{}
+
+	name, id string
+	err      error
+	scans    int
+}
+
+// acquire returns the snapshot of vm, taking it unless a running scan of
+// the vm already has. Every call has to be followed by one to release.
+func (s *vmSnapshots) acquire(ctx context.Context, vm *object.VirtualMachine) (*vmSnapshot, error) {
+	for {
+		s.mu.Lock()
+		if s.byVM == nil {
+			s.byVM = map[types.ManagedObjectReference]*vmSnapshot{}
+		}
+
+		snap, ok := s.byVM[vm.Reference()]
+		switch {
+		case !ok:
+			snap = &vmSnapshot{taken: make(chan struct{}), removed: make(chan struct{}), scans: 1}
+			s.byVM[vm.Reference()] = snap
+			s.mu.Unlock()
+
+			snap.name, snap.id, snap.err = takeSnapshot(ctx, vm)
+			close(snap.taken)
+
+			return snap, snap.err
+		case snap.scans == 0:
+			// the last scan is removing it; take a new one after
+			s.mu.Unlock()
+			select {
+			case <-snap.removed:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		default:
+			snap.scans++
+			s.mu.Unlock()
+
+			<-snap.taken
+			return snap, snap.err
+		}
+	}
+}
+
+// release is done with snap, the snapshot of vm, removing it if cleanup is
+// set and this was its last scan.
+func (s *vmSnapshots) release(vm *object.VirtualMachine, snap *vmSnapshot, cleanup bool) {
+	if snap == nil {
+		return
+	}
+
+	s.mu.Lock()
+	snap.scans--
+	last := snap.scans == 0
+	s.mu.Unlock()
+	if !last {
+		return
+	}
+
+	if cleanup && snap.err == nil {
+		// the snapshot has to go even after an interrupt
+		cleanupCtx := context.Background()
+
+		slog.Info("removing snapshot", "vm", vm.Reference().Value, "snapshot", snap.name, "id", snap.id)
+		task, err := vm.RemoveSnapshot(cleanupCtx, snap.id, false, nil)
+		if err == nil {
+			err = task.Wait(cleanupCtx)
+		}
+		if err != nil {
+			slog.Warn("can't remove snapshot", "vm", vm.Reference().Value, "snapshot", snap.name, "err", err)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.byVM, vm.Reference())
+	s.mu.Unlock()
+	close(snap.removed)
+}
+
+// takeSnapshot snapshots vm, returning the name of the snapshot and its
+// id, which unlike the name is unique.
+func takeSnapshot(ctx context.Context, vm *object.VirtualMachine) (string, string, error) {
+	name := snapshotPrefix + time.Now().UTC().Format("20060102T150405Z")
+
+	slog.Info("snapshotting vm", "vm", vm.Reference().Value, "snapshot", name)
+	task, err := vm.CreateSnapshot(ctx, name, "Taken before a compliance scan", false, false)
+	if err != nil {
+		return name, "", err
+	}
+
+	info, err := task.WaitForResult(ctx)
+	if err != nil {
+		return name, "", err
+	}
+
+	// RemoveSnapshot takes the id of the snapshot as well as its name
+	id := name
+	if ref, ok := info.Result.(types.ManagedObjectReference); ok {
+		id = ref.Value
+	}
+
+	return name, id, nil
+}
@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// countSnapshots returns how many snapshots vm has.
+func countSnapshots(ctx context.Context, vm *object.VirtualMachine) (int, error) {
+	var data mo.VirtualMachine
+	if err := vm.Properties(ctx, vm.Reference(), []string{"snapshot"}, &data); err != nil {
+		return 0, err
+	}
+	if data.Snapshot == nil {
+		return 0, nil
+	}
+
+	var count func(trees []types.VirtualMachineSnapshotTree) int
+	count = func(trees []types.VirtualMachineSnapshotTree) int {
+		n := len(trees)
+		for _, t := range trees {
+			n += count(t.ChildSnapshotList)
+		}
+		return n
+	}
+
+	return count(data.Snapshot.RootSnapshotList), nil
+}
+
+// snapshotCounter is a Runner that waits for all of its scans to be
+// running, and records how many snapshots their vms have meanwhile.
+type snapshotCounter struct {
+	c       *vim25.Client
+	running sync.WaitGroup
+	mu      sync.Mutex
+	counts  []int
+}
+
+func (r *snapshotCounter) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	res := Result{Target: t.Name, Profile: profile}
+
+	r.running.Done()
+	all := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(all)
+	}()
+	select {
+	case <-all:
+	case <-time.After(10 * time.Second):
+		return res, errors.New("scans of the same vm didn't run at once")
+	}
+
+	vm, err := inventory.FindVM(ctx, r.c, t.VM.UUID)
+	if err != nil {
+		return res, err
+	}
+	n, err := countSnapshots(ctx, vm)
+	if err != nil {
+		return res, err
+	}
+
+	r.mu.Lock()
+	r.counts = append(r.counts, n)
+	r.mu.Unlock()
+
+	return res, nil
+}
+
+func TestSnapshotRunnerSharesSnapshotSimulator(t *testing.T) {
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+		vms, err := find.NewFinder(c, true).VirtualMachineList(ctx, "*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm := vms[0]
+
+		var data mo.VirtualMachine
+		if err := vm.Properties(ctx, vm.Reference(), []string{"config.instanceUuid"}, &data); err != nil {
+			t.Fatal(err)
+		}
+
+		targets := splitProfiles([]TargetConfig{{
+			Name:    vm.InventoryPath,
+			Target:  targetURI(transportSSH, "10.0.0.5"),
+			Profile: "linux-baseline+ssh-baseline+cis-baseline",
+			VM:      inventory.VMInfo{Name: vm.Name(), UUID: data.Config.InstanceUuid},
+		}})
+
+		counter := &snapshotCounter{c: c}
+		counter.running.Add(len(targets))
+		r := snapshotRunner{Runner: counter, c: c, cleanup: true, snapshots: &vmSnapshots{}}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(targets))
+		for i, tt := range targets {
+			wg.Add(1)
+			go func(i int, tt TargetConfig) {
+				defer wg.Done()
+				_, errs[i] = r.Run(ctx, tt, tt.Profile)
+			}(i, tt)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("%s: %s", targets[i].Name, err)
+			}
+		}
+		for _, n := range counter.counts {
+			if n != 1 {
+				t.Errorf("vm had %d snapshots while scanned, want the one shared", n)
+			}
+		}
+
+		n, err := countSnapshots(ctx, vm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("vm has %d snapshots after the scans, want them removed", n)
+		}
+	})
+}
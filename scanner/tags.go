@@ -0,0 +1,113 @@
+package scanner
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// tagFilter keeps the vms that carry every wanted tag. A wanted tag is
+// either "category:tag" or just "tag".
+type tagFilter struct {
+	m    *tags.Manager
+	want []string
+
+	// hosts are filtered concurrently
+	mu         sync.Mutex
+	categories map[string]string
+}
+
+func newTagFilter(m *tags.Manager, want []string) *tagFilter {
+	return &tagFilter{
+		m:          m,
+		want:       want,
+		categories: map[string]string{},
+	}
+}
+
+// category returns the name of the category with id, caching lookups.
+func (f *tagFilter) category(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	name, ok := f.categories[id]
+	f.mu.Unlock()
+	if ok {
+		return name, nil
+	}
+
+	// the REST calls don't go through limitedRoundTripper
+	if err := apiLimiter.Wait(ctx); err != nil {
+		return "", err
+	}
+
+	c, err := f.m.GetCategory(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	f.categories[id] = c.Name
+	f.mu.Unlock()
+
+	return c.Name, nil
+}
+
+// Filter returns the vms that carry every wanted tag, looking up the tags
+// of all vms in a single call.
+func (f *tagFilter) Filter(ctx context.Context, vms []inventory.VMInfo) ([]inventory.VMInfo, error) {
+	if len(f.want) == 0 || len(vms) == 0 {
+		return vms, nil
+	}
+
+	refs := make([]mo.Reference, len(vms))
+	for i := range vms {
+		refs[i] = vms[i].Ref
+	}
+
+	if err := apiLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	attached, err := f.m.GetAttachedTagsOnObjects(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	have := map[string]map[string]bool{}
+	for _, a := range attached {
+		names := map[string]bool{}
+		for _, t := range a.Tags {
+			category, err := f.category(ctx, t.CategoryID)
+			if err != nil {
+				return nil, err
+			}
+
+			names[t.Name] = true
+			names[category+":"+t.Name] = true
+		}
+
+		have[a.ObjectID.Reference().Value] = names
+	}
+
+	var keep []inventory.VMInfo
+	for _, vm := range vms {
+		if hasAll(have[vm.Ref.Value], f.want) {
+			keep = append(keep, vm)
+		}
+	}
+
+	return keep, nil
+}
+
+func hasAll(have map[string]bool, want []string) bool {
+	for _, w := range want {
+		if !have[strings.TrimSpace(w)] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,527 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type TargetConfig struct {
+	Name             string                            `json:"-"`
+	Target           string                            `json:"target,omitempty"`
+	Port             int                               `json:"port,omitempty"`
+	User             string                            `json:"user,omitempty"`
+	Password         string                            `json:"password,omitempty"`
+	KeyFiles         []string                          `json:"key_files,omitempty"`
+	Sudo             bool                              `json:"sudo,omitempty"`
+	SudoPassword     string                            `json:"sudo_password,omitempty"`
+	SudoOptions      string                            `json:"sudo_options,omitempty"`
+	BastionHost      string                            `json:"bastion_host,omitempty"`
+	BastionUser      string                            `json:"bastion_user,omitempty"`
+	BastionPort      int                               `json:"bastion_port,omitempty"`
+	Insecure         bool                              `json:"insecure,omitempty"`
+	SSL              bool                              `json:"ssl,omitempty"`
+	Reporter         map[string]map[string]interface{} `json:"reporter,omitempty"`
+	LogLevel         string                            `json:"log-level,omitempty"`
+	InputFiles       []string                          `json:"input_file,omitempty"`
+	WaiverFiles      []string                          `json:"waiver_file,omitempty"`
+	CredentialSource string                            `json:"-"`
+	Profile          string                            `json:"-"`
+	Profiles         []string                          `json:"-"`
+	Skip             string                            `json:"-"`
+	VM               inventory.VMInfo                  `json:"-"`
+}
+
+// skipNotPoweredOn is why vms selected by --power-state that aren't running
+// are not scanned.
+const skipNotPoweredOn = "skipped - not powered on"
+
+// skipNoTools is why vms are not scanned with --only-with-tools when VMware
+// Tools isn't running in them.
+const skipNoTools = "skipped - VMware Tools not running"
+
+const vsphereProfile = "vsphere-6.5-U1-security-configuration-guide"
+
+// Scan stages
+const (
+	stageDiscovery   = "discovery"
+	stagePower       = "power"
+	stageExec        = "exec"
+	stageTimeout     = "timeout"
+	stageCredentials = "credentials"
+	stageConnect     = "connect"
+	stageSnapshot    = "snapshot"
+	stageDetect      = "detect"
+	stageClone       = "clone"
+	stageFacts       = "facts"
+)
+
+// ScanError records a failure for a single target without aborting the run.
+type ScanError struct {
+	Target string
+	Stage  string
+	Err    error
+}
+
+func (e ScanError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Target, e.Stage, e.Err)
+}
+
+// printScanErrors prints a table of failed scans.
+func printScanErrors(errs []ScanError) {
+	fmt.Fprintf(humanOut, "\n%d scans failed\n\n", len(errs))
+	w := new(tabwriter.Writer)
+	w.Init(humanOut, 0, 8, 1, '\t', 0)
+
+	fmt.Fprintf(w, "TARGET\tSTAGE\tERROR\n")
+	for _, e := range errs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Target, e.Stage, e.Err)
+	}
+
+	w.Flush()
+}
+
+// humanOut is where the tables meant for people are printed.
+var humanOut io.Writer = os.Stdout
+
+// powerStates are the valid --power-state values.
+var powerStates = []string{
+	string(types.VirtualMachinePowerStatePoweredOn),
+	string(types.VirtualMachinePowerStatePoweredOff),
+	string(types.VirtualMachinePowerStateSuspended),
+	"all",
+}
+
+// powerStateMatches reports whether a vm in state ps is selected by the
+// --power-state value want.
+func powerStateMatches(want string, ps types.VirtualMachinePowerState) bool {
+	return want == "all" || want == string(ps)
+}
+
+// ParseProfileMap parses a --profile-map value, e.g.
+// linuxGuest=linux-baseline,windows9Server64Guest=windows-baseline.
+func ParseProfileMap(s string) (map[string]string, error) {
+	m := map[string]string{}
+	if s == "" {
+		return m, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || slices.Contains(strings.Split(kv[1], profileSep), "") {
+			return nil, fmt.Errorf("invalid profile mapping %q", pair)
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return m, nil
+}
+
+// profileFor returns the profile for vm, matching its guest family first
+// and then its guest id. Without a map every vm gets the vSphere profile.
+func profileFor(profiles map[string]string, vm inventory.VMInfo) (string, bool) {
+	if len(profiles) == 0 {
+		return vsphereProfile, true
+	}
+
+	if p, ok := profiles[vm.GuestFamily]; ok {
+		return p, true
+	}
+
+	p, ok := profiles[vm.GuestID]
+	return p, ok
+}
+
+// requiredReporters are always written: json is read back to summarize and
+// merge results, and json-min is kept for the tools that read it.
+var requiredReporters = []string{"json", "json-min"}
+
+// reportExtensions are how the files of the InSpec reporters that write a
+// file end. Reporters not listed write to stdout.
+var reportExtensions = map[string]string{
+	"json":     ".json",
+	"json-min": "-min.json",
+	"html":     ".html",
+	"html2":    ".html",
+	"junit":    ".xml",
+	"junit2":   ".xml",
+	"yaml":     ".yml",
+}
+
+// reporterFormats returns the --reporter formats, cli by default, plus the
+// required ones.
+func reporterFormats(formats []string) []string {
+	if len(formats) == 0 {
+		formats = []string{"cli"}
+	}
+
+	for _, f := range requiredReporters {
+		if !slices.Contains(formats, f) {
+			formats = append(formats, f)
+		}
+	}
+
+	return formats
+}
+
+// buildReporter returns the InSpec reporter config writing formats to files
+// named after outPath, e.g. <uuid>.json and <uuid>-min.json for <uuid>.
+func buildReporter(formats []string, outPath string) map[string]map[string]interface{} {
+	reporter := map[string]map[string]interface{}{}
+
+	for _, f := range formats {
+		ext, ok := reportExtensions[f]
+		if !ok {
+			reporter[f] = map[string]interface{}{"stdout": true}
+			continue
+		}
+
+		reporter[f] = map[string]interface{}{"file": outPath + ext, "stdout": false}
+	}
+
+	return reporter
+}
+
+// outputName returns the base name of t's report files: the instance UUID
+// of its VM, which is stable across runs, or for a host, which has none, its
+// name. A target split by splitProfiles adds the number of its profile.
+func outputName(t TargetConfig) string {
+	name := "host-" + t.VM.Name
+	if t.VM.UUID != "" {
+		name = t.VM.UUID
+	}
+
+	if len(t.Profiles) > 1 {
+		name += fmt.Sprintf("-%d", slices.Index(t.Profiles, t.Profile)+1)
+	}
+
+	return name
+}
+
+// profileSep joins the profiles of a target scanned with several, e.g.
+// linux-baseline+ssh-baseline.
+const profileSep = "+"
+
+// splitProfiles returns targets with every target that has several
+// profiles split into one per profile, each scanned by an inspec run of its
+// own into reports of its own. The split targets are named after their
+// profile too, so their results are told apart wherever they are reported.
+func splitProfiles(targets []TargetConfig) []TargetConfig {
+	var out []TargetConfig
+	for _, t := range targets {
+		profiles := strings.Split(t.Profile, profileSep)
+		if t.Skip != "" || len(profiles) < 2 {
+			out = append(out, t)
+			continue
+		}
+
+		name := t.Name
+		for _, p := range profiles {
+			t.Name = fmt.Sprintf("%s [%s]", name, profileLabel(p))
+			t.Profile = p
+			t.Profiles = profiles
+			out = append(out, t)
+		}
+	}
+
+	return out
+}
+
+// profileLabel shortens profile to its last path element, e.g. ssh-baseline
+// for https://github.com/dev-sec/ssh-baseline.git.
+func profileLabel(profile string) string {
+	return strings.TrimSuffix(filepath.Base(profile), ".git")
+}
+
+// reportFile returns the file t's format reporter writes to, if any.
+func reportFile(t TargetConfig, format string) string {
+	if r, ok := t.Reporter[format]; ok {
+		if f, ok := r["file"].(string); ok {
+			return f
+		}
+	}
+
+	return ""
+}
+
+// redacted returns a copy of t with its passwords masked.
+func redacted(t TargetConfig) TargetConfig {
+	if t.Password != "" {
+		t.Password = "********"
+	}
+	if t.SudoPassword != "" {
+		t.SudoPassword = "********"
+	}
+
+	return t
+}
+
+// printPlan prints the inspec invocation r would make for t on a single
+// line without running it. Passwords are masked.
+func printPlan(r CLIRunner, t TargetConfig) {
+	if t.Skip != "" {
+		fmt.Printf("skip\tname=%s\treason=%s\n", t.Name, t.Skip)
+		return
+	}
+
+	conf, err := json.Marshal(redacted(t))
+	if err != nil {
+		slog.Error("can't build plan", "target", t.Name, "err", err)
+		return
+	}
+
+	fmt.Printf("plan\tname=%s\tip=%s\tprofile=%s\tcmd=%s %s\tconfig=%s\n",
+		t.Name, t.Target, t.Profile, r.Bin, strings.Join(r.Args(t.Profile), " "), conf)
+}
+
+// runScans runs r against targets using a bounded pool of workers, at most
+// workersPerHost of them on the targets of any one host if it's positive.
+// With a failFast policy it stops like on an interrupt once the results of
+// a target cross it. A limiter further bounds the scans running at once.
+// The duration of every scan run is returned by target name, in
+// milliseconds.
+func runScans(ctx context.Context, grace time.Duration, r Runner, targets []TargetConfig, concurrency, workersPerHost int, failFast string, limiter *adaptiveLimiter) ([]ScanError, map[string]int64) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// a slot per scan allowed on each host; interleaving the hosts keeps
+	// workers from queueing up behind the same busy one
+	var slots map[string]chan struct{}
+	if workersPerHost > 0 {
+		targets = interleaveHosts(targets)
+		slots = map[string]chan struct{}{}
+		for _, t := range targets {
+			if _, ok := slots[hostKey(t)]; !ok {
+				slots[hostKey(t)] = make(chan struct{}, workersPerHost)
+			}
+		}
+	}
+
+	// no new scans start once ctx is done, but running ones get grace to
+	// finish before they are killed
+	scanCtx, cancel := graceContext(ctx, grace)
+	defer cancel()
+
+	var total int
+	for _, t := range targets {
+		if t.Skip == "" {
+			total++
+		}
+	}
+	p := newProgress(total, progressOut)
+	defer p.Finish()
+
+	jobs := make(chan TargetConfig)
+	var wg sync.WaitGroup
+	var results ResultCollector
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				slot := slots[hostKey(t)]
+				if slot != nil {
+					select {
+					case slot <- struct{}{}:
+					case <-ctx.Done():
+						continue
+					}
+				}
+
+				if !limiter.Acquire(ctx) {
+					if slot != nil {
+						<-slot
+					}
+					continue
+				}
+
+				res, err := scanTarget(scanCtx, r, t)
+				limiter.Release(err != nil)
+				if slot != nil {
+					<-slot
+				}
+				// a runner that panicked returned no Result
+				res.Target = t.Name
+				results.Add(res, err)
+				if err != nil {
+					slog.Warn("scan failed", "target", t.Name, "err", err)
+				}
+				p.Done(err != nil)
+
+				if err == nil && failFast != FailOnNone {
+					s, serr := readSummary(t.Name, reportFile(t, "json"))
+					if serr == nil && shouldFail([]Summary{s}, failFast) && ctx.Err() == nil {
+						slog.Warn("failing fast", "target", t.Name, "failed", s.Failed, "skipped", s.Skipped)
+						stop()
+					}
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, t := range targets {
+		if t.Skip != "" {
+			continue
+		}
+
+		select {
+		case jobs <- t:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	durations := map[string]int64{}
+	for _, res := range results.All() {
+		durations[res.Target] = res.DurationMs
+	}
+
+	return results.Errors(), durations
+}
+
+// hostKey identifies the ESXi host t runs on, by managed object reference
+// if known, else by name as in a --cache-file.
+func hostKey(t TargetConfig) string {
+	if t.VM.HostRef.Value != "" {
+		return t.VM.HostRef.Value
+	}
+
+	return t.VM.Host
+}
+
+// interleaveHosts returns targets reordered to take one from every host in
+// turn, keeping their order within each host.
+func interleaveHosts(targets []TargetConfig) []TargetConfig {
+	var keys []string
+	byHost := map[string][]TargetConfig{}
+	for _, t := range targets {
+		k := hostKey(t)
+		if _, ok := byHost[k]; !ok {
+			keys = append(keys, k)
+		}
+		byHost[k] = append(byHost[k], t)
+	}
+
+	out := make([]TargetConfig, 0, len(targets))
+	for len(out) < len(targets) {
+		for _, k := range keys {
+			if ts := byHost[k]; len(ts) > 0 {
+				out = append(out, ts[0])
+				byHost[k] = ts[1:]
+			}
+		}
+	}
+
+	return out
+}
+
+// sortTargets sorts targets by host, then by vm name, then by inventory
+// path. Hosts sort right before their vms.
+func sortTargets(targets []TargetConfig) {
+	sort.SliceStable(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if a.VM.Host != b.VM.Host {
+			return a.VM.Host < b.VM.Host
+		}
+		if ah, bh := targetTransport(a) == transportVMware, targetTransport(b) == transportVMware; ah != bh {
+			return ah
+		}
+		if a.VM.Name != b.VM.Name {
+			return a.VM.Name < b.VM.Name
+		}
+		return a.Name < b.Name
+	})
+}
+
+// capTargets keeps the first max targets that would be scanned, returning
+// how many were dropped. Skipped targets cost nothing, so they are all
+// kept.
+func capTargets(targets []TargetConfig, max int) ([]TargetConfig, int) {
+	var kept []TargetConfig
+	var n, dropped int
+	for _, t := range targets {
+		if t.Skip == "" {
+			if n >= max {
+				dropped++
+				continue
+			}
+			n++
+		}
+
+		kept = append(kept, t)
+	}
+
+	return kept, dropped
+}
+
+// graceContext returns a context that is canceled grace after ctx is.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	gctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	stop := context.AfterFunc(ctx, func() {
+		if grace > 0 {
+			slog.Warn("interrupted, waiting for running scans", "grace", grace)
+		}
+		time.AfterFunc(grace, cancel)
+	})
+
+	return gctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// scanTarget runs a single scan with r, timing it. A panic is turned into
+// an error so one bad target can't take down the other workers.
+func scanTarget(ctx context.Context, r Runner, t TargetConfig) (res Result, err error) {
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+		res.DurationMs = time.Since(start).Milliseconds()
+	}()
+
+	return r.Run(ctx, t, t.Profile)
+}
+
+// execError wraps a failed scan of target, telling timeouts apart from
+// other failures.
+func execError(target string, err error) ScanError {
+	stage := stageExec
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		stage = stageTimeout
+	case errors.Is(err, errSnapshot):
+		stage = stageSnapshot
+	case errors.Is(err, errClone):
+		stage = stageClone
+	case errors.Is(err, errFacts):
+		stage = stageFacts
+	}
+
+	return ScanError{Target: target, Stage: stage, Err: err}
+}
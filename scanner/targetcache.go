@@ -0,0 +1,148 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+)
+
+// targetCache is the --cache-file format. It records what discovery found,
+// but never credentials: those are resolved again when the cache is used.
+type targetCache struct {
+	Created time.Time      `json:"created"`
+	VCenter vcenterInfo    `json:"vcenter"`
+	Targets []cachedTarget `json:"targets"`
+}
+
+// cachedTarget is a discovered target. Name is the inventory path of the
+// VM, or of the ESXi host if Host is set.
+type cachedTarget struct {
+	Name        string `json:"name"`
+	VMName      string `json:"vm_name"`
+	ESXiHost    string `json:"esxi_host,omitempty"`
+	UUID        string `json:"uuid,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	GuestFamily string `json:"guest_family,omitempty"`
+	GuestID     string `json:"guest_id,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	Skip        string `json:"skip,omitempty"`
+	Host        bool   `json:"host,omitempty"`
+	Template    bool   `json:"template,omitempty"`
+}
+
+// writeTargetCache writes the targets discovered in vc to path.
+func writeTargetCache(path string, vc vcenterInfo, targets []TargetConfig) error {
+	c := targetCache{
+		Created: time.Now().UTC(),
+		VCenter: vc,
+		Targets: []cachedTarget{},
+	}
+
+	for _, t := range targets {
+		c.Targets = append(c.Targets, cachedTarget{
+			Name:        t.Name,
+			VMName:      t.VM.Name,
+			ESXiHost:    t.VM.Host,
+			UUID:        t.VM.UUID,
+			IP:          t.VM.IP,
+			GuestFamily: t.VM.GuestFamily,
+			GuestID:     t.VM.GuestID,
+			Profile:     t.Profile,
+			Skip:        t.Skip,
+			Host:        targetTransport(t) == transportVMware,
+			Template:    t.VM.Template,
+		})
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b)
+}
+
+// loadTargetCache reads the targets cached at path, resolving their
+// credentials with opts. A cache older than ttl is refused unless force is
+// set.
+func loadTargetCache(path string, ttl time.Duration, force bool, opts discoverOptions) (vcenterInfo, []TargetConfig, []ScanError, error) {
+	var c targetCache
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c.VCenter, nil, nil, err
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c.VCenter, nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if age := time.Since(c.Created); age > ttl {
+		if !force {
+			return c.VCenter, nil, nil, fmt.Errorf("%s is %s old, older than %s; use --force to use it anyway", path, age.Round(time.Second), ttl)
+		}
+		slog.Warn("using stale target cache", "path", path, "age", age.Round(time.Second))
+	}
+
+	slog.Info("using target cache", "path", path, "vcenter", c.VCenter.Host, "created", c.Created, "targets", len(c.Targets))
+
+	var targets []TargetConfig
+	var scanErrs []ScanError
+	for _, ct := range c.Targets {
+		vm := inventory.VMInfo{
+			Name:          ct.VMName,
+			Host:          ct.ESXiHost,
+			InventoryPath: ct.Name,
+			UUID:          ct.UUID,
+			IP:            ct.IP,
+			GuestFamily:   ct.GuestFamily,
+			GuestID:       ct.GuestID,
+		}
+
+		if ct.Skip != "" {
+			targets = append(targets, TargetConfig{Name: ct.Name, Skip: ct.Skip, VM: vm})
+			continue
+		}
+
+		// cloning needs the template's host and folder, which aren't cached
+		if ct.Template {
+			slog.Warn("can't scan a template from the target cache, skipping", "vm", ct.VMName)
+			continue
+		}
+
+		if ct.Host {
+			h := inventory.HostInfo{Name: ct.VMName, InventoryPath: ct.Name, IP: ct.IP}
+
+			cred, ok, err := hostCredentialFor(opts, h)
+			if err != nil {
+				scanErrs = append(scanErrs, ScanError{Target: ct.Name, Stage: stageCredentials, Err: err})
+				continue
+			}
+			if !ok {
+				slog.Warn("no credentials for host, skipping", "host", ct.Name)
+				continue
+			}
+
+			targets = append(targets, newHostTarget(opts, h, cred))
+			continue
+		}
+
+		cred, ok, err := credentialFor(opts, vm)
+		if err != nil {
+			scanErrs = append(scanErrs, ScanError{Target: ct.Name, Stage: stageCredentials, Err: err})
+			continue
+		}
+		if !ok {
+			slog.Warn("no credentials for vm, skipping", "vm", ct.VMName, "ip", ct.IP)
+			continue
+		}
+
+		targets = append(targets, newTarget(opts, vm, ct.Profile, cred))
+	}
+
+	return c.VCenter, targets, scanErrs, nil
+}
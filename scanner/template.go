@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gpeers/vmware-poc/inventory"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// clonePrefix starts the names of the template clones, so any left behind
+// are easy to recognize and clean up by hand.
+const clonePrefix = "vmware-poc-scan-"
+
+// defaultCloneIPWait is how long a template clone gets to come up with an
+// IP when --wait-for-ip isn't set.
+const defaultCloneIPWait = 10 * time.Minute
+
+// errClone marks template scans that didn't run because the template
+// couldn't be cloned and started.
+var errClone = errors.New("can't clone template")
+
+// templateRunner scans templates, which can't be powered on, through a
+// clone of them: the clone is powered on, scanned once it has an IP, and
+// destroyed again whatever happened. Other targets are passed on.
+type templateRunner struct {
+	Runner
+	c         *vim25.Client
+	waitForIP time.Duration
+}
+
+func (r templateRunner) Run(ctx context.Context, t TargetConfig, profile string) (Result, error) {
+	if !t.VM.Template {
+		return r.Runner.Run(ctx, t, profile)
+	}
+
+	res := Result{Target: t.Name, Profile: profile}
+
+	name := clonePrefix + t.VM.Name + "-" + time.Now().UTC().Format("20060102T150405Z")
+
+	slog.Info("cloning template", "target", t.Name, "clone", name)
+	clone, err := cloneTemplate(ctx, r.c, t.VM, name)
+	if clone != nil {
+		// the clone has to go even after an interrupt
+		defer destroyClone(context.Background(), clone, t.Name)
+	}
+	if err != nil {
+		return res, fmt.Errorf("%w: %s", errClone, err)
+	}
+
+	ip, err := waitForCloneIP(ctx, clone, r.waitForIP)
+	if err != nil {
+		return res, fmt.Errorf("%w: no IP: %s", errClone, err)
+	}
+
+	var mvm mo.VirtualMachine
+	if err := clone.Properties(ctx, clone.Reference(), []string{"summary.config.instanceUuid"}, &mvm); err != nil {
+		return res, fmt.Errorf("%w: %s", errClone, err)
+	}
+
+	t.VM.IP = ip
+	t.VM.UUID = mvm.Summary.Config.InstanceUuid
+	if transport := targetTransport(t); transport == TransportGuestOps {
+		t.Target = targetURI(transport, t.VM.UUID)
+	} else {
+		t.Target = targetURI(transport, ip)
+	}
+
+	return r.Runner.Run(ctx, t, profile)
+}
+
+// cloneTemplate clones the template vm to a powered on vm called name, on
+// the same host and in the same folder. The clone is returned even when it
+// was created but failed to start, so it can be cleaned up.
+func cloneTemplate(ctx context.Context, c *vim25.Client, vm inventory.VMInfo, name string) (*object.VirtualMachine, error) {
+	template := object.NewVirtualMachine(c, vm.Ref)
+
+	var mvm mo.VirtualMachine
+	if err := template.Properties(ctx, vm.Ref, []string{"parent"}, &mvm); err != nil {
+		return nil, err
+	}
+	if mvm.Parent == nil {
+		return nil, fmt.Errorf("template %s has no folder", vm.Name)
+	}
+
+	// templates have no pool of their own
+	pool, err := object.NewHostSystem(c, vm.HostRef).ResourcePool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	poolRef := pool.Reference()
+	hostRef := vm.HostRef
+
+	spec := types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{Pool: &poolRef, Host: &hostRef},
+		PowerOn:  true,
+	}
+
+	task, err := template.Clone(ctx, object.NewFolder(c, *mvm.Parent), name, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := task.WaitForResult(ctx)
+	if info != nil {
+		if ref, ok := info.Result.(types.ManagedObjectReference); ok {
+			return object.NewVirtualMachine(c, ref), err
+		}
+	}
+	if err == nil {
+		err = errors.New("clone task returned no vm")
+	}
+
+	return nil, err
+}
+
+// waitForCloneIP waits up to timeout for the guest of clone to report an
+// IP.
+func waitForCloneIP(ctx context.Context, clone *object.VirtualMachine, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ip, err := clone.WaitForIP(ctx)
+	if err == nil && strings.TrimSpace(ip) == "" {
+		err = errors.New("guest reported no IP")
+	}
+
+	return ip, err
+}
+
+// destroyClone powers clone off and deletes it, logging what fails. The
+// power off fails harmlessly if it never came on.
+func destroyClone(ctx context.Context, clone *object.VirtualMachine, target string) {
+	slog.Info("destroying template clone", "target", target)
+
+	if task, err := clone.PowerOff(ctx); err == nil {
+		if err := task.Wait(ctx); err != nil {
+			slog.Debug("can't power off template clone", "target", target, "err", err)
+		}
+	}
+
+	task, err := clone.Destroy(ctx)
+	if err == nil {
+		err = task.Wait(ctx)
+	}
+	if err != nil {
+		slog.Warn("can't destroy template clone, remove it by hand", "target", target, "clone", clone.Reference().Value, "err", err)
+	}
+}
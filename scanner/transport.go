@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"net/url"
+	"strings"
+)
+
+// InSpec transports
+const (
+	transportSSH    = "ssh"
+	transportWinRM  = "winrm"
+	transportVMware = "vmware"
+)
+
+// guest transports, the --transport values. TransportGuestOps isn't an
+// InSpec transport: GuestOpsRunner runs inspec inside the guest instead.
+const (
+	TransportAuto     = "auto"
+	TransportGuestOps = "guestops"
+)
+
+// GuestTransports are the valid --transport values.
+var GuestTransports = []string{TransportAuto, TransportGuestOps}
+
+// windowsGuestFamily is the vSphere guest family of Windows guests.
+const windowsGuestFamily = "windowsGuest"
+
+// TransportForGuest returns the InSpec transport used to reach a guest of
+// the given vSphere guest family. Everything but Windows is reached by ssh.
+func TransportForGuest(family string) string {
+	if family == windowsGuestFamily {
+		return transportWinRM
+	}
+
+	return transportSSH
+}
+
+// targetURI returns the InSpec target for host over transport. IPv6
+// addresses are bracketed, as in any URL.
+func targetURI(transport, host string) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	return transport + "://" + host
+}
+
+// targetHost returns the host part of t's InSpec target, e.g. the guest IP.
+func targetHost(t TargetConfig) string {
+	u, err := url.Parse(t.Target)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
+// targetTransport returns the transport of t's InSpec target.
+func targetTransport(t TargetConfig) string {
+	u, err := url.Parse(t.Target)
+	if err != nil {
+		return ""
+	}
+
+	return u.Scheme
+}
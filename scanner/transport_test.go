@@ -0,0 +1,21 @@
+package scanner
+
+import "testing"
+
+func TestTransportForGuest(t *testing.T) {
+	tests := []struct {
+		family string
+		want   string
+	}{
+		{"linuxGuest", transportSSH},
+		{"windowsGuest", transportWinRM},
+		{"otherGuest", transportSSH},
+		{"", transportSSH},
+	}
+
+	for _, tt := range tests {
+		if got := TransportForGuest(tt.family); got != tt.want {
+			t.Errorf("TransportForGuest(%q) = %q, want %q", tt.family, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// WaiverRule is an InSpec waiver file for the vms and ESXi hosts whose
+// name, not inventory path, matches the Name glob, e.g. web-*, on top of
+// those of --waiver-file.
+type WaiverRule struct {
+	Name string `yaml:"name"`
+	File string `yaml:"file"`
+}
+
+// checkWaivers checks that the waiver files of files and rules exist, and
+// that the rules' globs are valid, so a typo fails before any scan.
+func checkWaivers(files []string, rules []WaiverRule) error {
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			return err
+		}
+	}
+
+	for i, r := range rules {
+		if _, err := path.Match(r.Name, ""); err != nil {
+			return fmt.Errorf("waiver rule %d: bad name %q: %s", i+1, r.Name, err)
+		}
+		if _, err := os.Stat(r.File); err != nil {
+			return fmt.Errorf("waiver rule %d: %s", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// waiverFilesFor returns the waiver files of t: files, then those of every
+// rule matching the name of its vm or host, whichever of its profiles t
+// scans.
+func waiverFilesFor(files []string, rules []WaiverRule, t TargetConfig) []string {
+	out := append([]string(nil), files...)
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Name, t.VM.Name); ok {
+			out = append(out, r.File)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,42 @@
+package scanner
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gpeers/vmware-poc/inventory"
+)
+
+func TestWaiverFilesFor(t *testing.T) {
+	files := []string{"all.yml"}
+	rules := []WaiverRule{
+		{Name: "web-*", File: "web.yml"},
+		{Name: "esx-*", File: "esx.yml"},
+		{Name: "*", File: "every.yml"},
+	}
+
+	web := TargetConfig{Name: "/DC/vm/web-1", Profile: "linux-baseline", VM: inventory.VMInfo{Name: "web-1"}}
+	split := splitProfiles([]TargetConfig{{Name: "/DC/vm/web-2", Profile: "linux-baseline+ssh-baseline", VM: inventory.VMInfo{Name: "web-2"}}})
+	host := TargetConfig{Name: "/DC/host/esx-1", VM: inventory.VMInfo{Name: "esx-1"}}
+	db := TargetConfig{Name: "/DC/vm/db-1", VM: inventory.VMInfo{Name: "db-1"}}
+
+	tests := []struct {
+		t    TargetConfig
+		want []string
+	}{
+		{web, []string{"all.yml", "web.yml", "every.yml"}},
+		{split[0], []string{"all.yml", "web.yml", "every.yml"}},
+		{split[1], []string{"all.yml", "web.yml", "every.yml"}},
+		{host, []string{"all.yml", "esx.yml", "every.yml"}},
+		{db, []string{"all.yml", "every.yml"}},
+	}
+	for _, tt := range tests {
+		if got := waiverFilesFor(files, rules, tt.t); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("waiverFilesFor(%s) = %v, want %v", tt.t.Name, got, tt.want)
+		}
+	}
+
+	if got := waiverFilesFor(files, rules, web); &got[0] == &files[0] {
+		t.Error("waiverFilesFor returned files itself")
+	}
+}
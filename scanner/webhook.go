@@ -0,0 +1,149 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --webhook-format values
+const (
+	WebhookJSON  = "json"
+	WebhookSlack = "slack"
+)
+
+var WebhookFormats = []string{WebhookJSON, WebhookSlack}
+
+// webhookTimeout bounds the whole webhook call.
+const webhookTimeout = 10 * time.Second
+
+// topFailing is how many of the most failed controls a webhook lists.
+const topFailing = 5
+
+// WebhookSummary is the body posted to --webhook-url in the json format.
+type WebhookSummary struct {
+	Status             string         `json:"status"`
+	ExitCode           int            `json:"exit_code"`
+	VCenter            string         `json:"vcenter"`
+	Totals             JSONTotals     `json:"totals"`
+	TopFailingControls []ControlCount `json:"top_failing_controls"`
+}
+
+// ControlCount is the number of targets that failed a control.
+type ControlCount struct {
+	ID      string `json:"id"`
+	Targets int    `json:"targets"`
+}
+
+// newWebhookSummary summarizes a run that exited with code.
+func newWebhookSummary(vcenter string, code int, totals JSONTotals, targets []TargetConfig) WebhookSummary {
+	s := WebhookSummary{
+		Status:             "success",
+		ExitCode:           code,
+		VCenter:            vcenter,
+		Totals:             totals,
+		TopFailingControls: topFailingControls(targets, topFailing),
+	}
+
+	if code != ExitOK {
+		s.Status = "failure"
+	}
+
+	return s
+}
+
+// topFailingControls returns the n controls failed by the most targets.
+func topFailingControls(targets []TargetConfig, n int) []ControlCount {
+	counts := map[string]int{}
+	for _, t := range targets {
+		if t.Skip != "" {
+			continue
+		}
+
+		controls, _, err := readControls(reportFile(t, "json"))
+		if err != nil {
+			continue
+		}
+
+		for _, c := range controls {
+			if c.Status == statusFailed {
+				counts[c.ID]++
+			}
+		}
+	}
+
+	top := []ControlCount{}
+	for id, count := range counts {
+		top = append(top, ControlCount{ID: id, Targets: count})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Targets != top[j].Targets {
+			return top[i].Targets > top[j].Targets
+		}
+		return top[i].ID < top[j].ID
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
+
+// slackMessage formats s as a Slack incoming webhook message.
+func slackMessage(s WebhookSummary) map[string]string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Compliance run on %s: *%s* (exit code %d)\n", s.VCenter, s.Status, s.ExitCode)
+	fmt.Fprintf(&b, "%d targets, %d errored; controls: %d passed, %d failed, %d skipped",
+		s.Totals.Targets, s.Totals.Errored, s.Totals.Passed, s.Totals.Failed, s.Totals.Skipped)
+
+	if len(s.TopFailingControls) > 0 {
+		b.WriteString("\nTop failing controls:")
+		for _, c := range s.TopFailingControls {
+			fmt.Fprintf(&b, "\n• %s (%d targets)", c.ID, c.Targets)
+		}
+	}
+
+	return map[string]string{"text": b.String()}
+}
+
+// postWebhook posts s to url in format.
+func postWebhook(ctx context.Context, url string, format string, s WebhookSummary) error {
+	var body interface{} = s
+	if format == WebhookSlack {
+		body = slackMessage(s)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", res.Status)
+	}
+
+	return nil
+}